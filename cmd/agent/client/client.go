@@ -2,19 +2,36 @@
 package client
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"google.golang.org/grpc"
 
 	"github.com/aykuli/observer/internal/agent/config"
+	"github.com/aykuli/observer/internal/agent/queue"
 	"github.com/aykuli/observer/internal/models"
+	pb "github.com/aykuli/observer/internal/proto"
 	"github.com/aykuli/observer/internal/sign"
+	"github.com/aykuli/observer/internal/tlsconfig"
 
 	"github.com/aykuli/observer/internal/agent/storage"
 	"github.com/aykuli/observer/internal/compressor"
+	"github.com/aykuli/observer/internal/encryptor"
 )
 
 // Retry configuration constants if server doesn't respond
@@ -24,28 +41,235 @@ const (
 	RetryMaxWaitTimeSeconds = 5 //  max wait time to sleep before retrying request.
 )
 
+// realIPHeader is request header server checks against trusted subnet.
+const realIPHeader = "X-Real-IP"
+
+// Batch sending errors
+var (
+	errServerUnavailable = errors.New("server is unavailable")
+	errRejected          = errors.New("server rejected metrics")
+	errRefused           = errors.New("server refused agent")
+)
+
 // MetricsClient struct is used to metric sender client with settings,
 // like server address string, metrics storage pointer,
-// request sign key and limit of request counts to server.
+// request sign key, limit of request counts to server, queue of unsent batches,
+// server public key to encrypt requests, TLS settings, gRPC connection used instead of HTTP if configured,
+// agent outbound address sent in X-Real-IP header, labels attached to every metric
+// and agent identity sent in headers: ID, build version and report interval.
 type MetricsClient struct {
 	ServerAddr string
+	Version    string
 	memStorage *storage.MemStorage
 	signKey    string
 	limit      int
+	queue      *queue.Queue
+	publicKey  *rsa.PublicKey
+	tlsConfig  *tls.Config
+	conn       *grpc.ClientConn
+	rpc        pb.MetricsClient
+	realIP     string
+	labels     map[string]string
+	agentID    string
+	interval   int
 }
 
 // NewMetricsClient creates a new client for agent application.
-func NewMetricsClient(config config.Config, memStorage *storage.MemStorage) *MetricsClient {
-	return &MetricsClient{
-		ServerAddr: "http://" + config.Address,
+// If queue directory is configured, batches failed to be sent are kept on disk and sent later.
+// Error is returned if configured crypto key or TLS files can't be loaded, metrics are never sent in clear text then.
+// Error is returned if configured labels are invalid or queue directory is used by another agent too.
+func NewMetricsClient(config config.Config, memStorage *storage.MemStorage) (*MetricsClient, error) {
+	labels, err := agentLabels(config.Labels, config.HostLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	useTLS := config.TLSCA != "" || config.TLSCert != ""
+	client := &MetricsClient{
+		ServerAddr: serverURL(config.Address, useTLS),
 		memStorage: memStorage,
 		signKey:    config.Key,
 		limit:      config.RateLimit,
+		labels:     labels,
+		agentID:    config.AgentID,
+		interval:   config.ReportInterval,
+	}
+
+	if client.agentID == "" {
+		if client.agentID, err = os.Hostname(); err != nil {
+			log.Printf("Err detecting hostname, agent ID won't be sent: %+v", err)
+		}
+	}
+
+	if useTLS {
+		tlsConfig, err := tlsconfig.Client(config.TLSCA, config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		client.tlsConfig = tlsConfig
+	}
+
+	if config.GRPCAddress != "" {
+		if config.CryptoKey != "" {
+			return nil, errGRPCEncryption
+		}
+		if err := client.dialGRPC(config.GRPCAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.CryptoKey != "" {
+		publicKey, err := encryptor.LoadPublicKey(config.CryptoKey)
+		if err != nil {
+			return nil, err
+		}
+		client.publicKey = publicKey
+	}
+
+	serverHost := config.GRPCAddress
+	if serverHost == "" {
+		serverHost = hostPort(client.ServerAddr)
+	}
+	if realIP, err := outboundIP(serverHost); err != nil {
+		log.Printf("Err detecting agent address, X-Real-IP won't be sent: %+v", err)
+	} else {
+		client.realIP = realIP
+	}
+
+	if config.QueueDir != "" {
+		q, err := queue.Open(config.QueueDir, config.QueueMaxBytes, time.Duration(config.QueueMaxAge)*time.Second)
+		if errors.Is(err, queue.ErrLocked) {
+			client.Close()
+			return nil, fmt.Errorf("%w: %s, set other queue directory for every agent", err, config.QueueDir)
+		}
+		if err != nil {
+			log.Printf("Err opening send queue, unsent metrics won't be kept: %+v", err)
+		} else {
+			client.queue = q
+		}
+	}
+
+	return client, nil
+}
+
+// Close closes gRPC connection if it was opened and releases queue directory.
+func (m *MetricsClient) Close() error {
+	var errs []error
+	if m.conn != nil {
+		errs = append(errs, m.conn.Close())
+	}
+	if m.queue != nil {
+		errs = append(errs, m.queue.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// identity returns agent identity headers server keeps agents registry by, empty values aren't sent.
+// They are covered by request signature.
+func (m *MetricsClient) identity() map[string]string {
+	headers := make(map[string]string, 3)
+	if m.agentID != "" {
+		headers[sign.AgentIDHeader] = m.agentID
+	}
+	if m.Version != "" {
+		headers[sign.AgentVersionHeader] = m.Version
 	}
+	if m.interval > 0 {
+		headers[sign.ReportIntervalHeader] = strconv.Itoa(m.interval)
+	}
+	return headers
+}
+
+// agentLabels parses configured static labels. If hostLabel is set, host label with machine hostname
+// is added unless it is configured explicitly.
+func agentLabels(s string, hostLabel bool) (map[string]string, error) {
+	labels, err := models.ParseLabels(s)
+	if err != nil {
+		return nil, fmt.Errorf("wrong agent labels: %w", err)
+	}
+	if _, ok := labels["host"]; ok || !hostLabel {
+		return labels, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err == nil {
+		err = models.ValidateLabels(map[string]string{"host": hostname})
+	}
+	if err != nil {
+		log.Printf("Err detecting hostname, host label won't be sent: %+v", err)
+		return labels, nil
+	}
+	if labels == nil {
+		labels = make(map[string]string, 1)
+	}
+	labels["host"] = hostname
+	return labels, nil
+}
+
+// withLabels attaches agent labels to metrics, labels metric already has are kept.
+func (m *MetricsClient) withLabels(metrics []models.Metric) []models.Metric {
+	if len(m.labels) == 0 {
+		return metrics
+	}
+
+	for i := range metrics {
+		labels := make(map[string]string, len(m.labels)+len(metrics[i].Labels))
+		for k, v := range m.labels {
+			labels[k] = v
+		}
+		for k, v := range metrics[i].Labels {
+			labels[k] = v
+		}
+		metrics[i].Labels = labels
+	}
+	return metrics
+}
+
+// serverURL returns server address with scheme. If scheme isn't provided,
+// https is used when TLS is configured and http otherwise.
+func serverURL(address string, useTLS bool) string {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return strings.TrimSuffix(address, "/")
+	}
+	if useTLS {
+		return "https://" + address
+	}
+	return "http://" + address
+}
+
+// hostPort returns host and port of server URL, default scheme port is used if it isn't provided.
+func hostPort(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return ""
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// outboundIP returns address of network interface used to reach the server.
+// UDP socket isn't connected really, so no packets are sent.
+func outboundIP(serverHost string) (string, error) {
+	conn, err := net.Dial("udp", serverHost)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return "", fmt.Errorf("unexpected local address %s", conn.LocalAddr())
+	}
+	return addr.IP.String(), nil
 }
 
 // newRestyClient creates configured resty client for metrics client methods
-func newRestyClient() *resty.Client {
+func newRestyClient(tlsConfig *tls.Config) *resty.Client {
 	restyClient := resty.New().
 		SetRetryCount(RetryCount).
 		SetRetryWaitTime(RetryMinWaitTimeSeconds).
@@ -55,6 +279,9 @@ func newRestyClient() *resty.Client {
 			isServerDBErr := r.StatusCode() == http.StatusInternalServerError
 			return isConnRefused || isServerDBErr
 		})
+	if tlsConfig != nil {
+		restyClient.SetTLSClientConfig(tlsConfig)
+	}
 	restyClient.OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
 		r.SetHeader("Content-Encoding", "gzip")
 		r.SetHeader("Accept-Encoding", "gzip")
@@ -66,130 +293,201 @@ func newRestyClient() *resty.Client {
 }
 
 // SendMetrics method send metrics one by one. Request quantity might be limited.
-// If not, limit will be the same as quantity of metrics
-func (m *MetricsClient) SendMetrics() {
-	metrics := m.memStorage.GetAllMetrics()
-
-	var wg sync.WaitGroup
-	// Создаю каналы, в них должны поместиться все метрики и все ошибки в случае, если сервер не работает и лимит равен количеству всех метрик.
-	jobs := make(chan models.Metric, len(metrics))
-	errs := make(chan error, len(metrics))
+// If not, limit will be the same as quantity of metrics.
+// Every counter delta is acknowledged separately as soon as server accepts it. If server is unavailable,
+// metrics not sent yet are left pending till the next report. The same happens when ctx is done.
+func (m *MetricsClient) SendMetrics(ctx context.Context) {
+	metrics := m.withLabels(m.memStorage.GetAllMetrics())
+	if len(metrics) == 0 {
+		return
+	}
 
-	// Если лимит больше количества метрик, незачем создавать лишние горутины
-	if m.limit > len(metrics) {
-		m.limit = len(metrics)
+	limit := m.limit
+	if limit <= 0 || limit > len(metrics) {
+		limit = len(metrics)
 	}
 
-	// Сначала создаю слушателей
-	// Создаю лимитированное количество горутин, которые конкурентно слушают канал jobs
-	for w := 0; w < m.limit; w++ {
-		// Инкрементирую вейт группу для каждой горутины
+	var wg sync.WaitGroup
+	var unavailable atomic.Bool
+	jobs := make(chan models.Metric)
+
+	for w := 0; w < limit; w++ {
 		wg.Add(1)
 		go func() {
-			// Как горутина закончила работу, декремент вейт группы
 			defer wg.Done()
 			for metric := range jobs {
-				if err := m.sendOneMetric(metric); err != nil {
-					// Если ошибка случается, сохраняю в канал ошибок
-					errs <- err
-					return
+				err := m.sendOneMetric(ctx, metric)
+				switch {
+				case err == nil:
+					m.memStorage.AckCounters([]models.Metric{metric})
+				case errors.Is(err, errRejected):
+					// Server won't accept this value on retry, it is dropped.
+					log.Printf("Err sending metric %s with err %+v", metric.ID, err)
+					m.memStorage.AckCounters([]models.Metric{metric})
+				default:
+					log.Printf("Err sending metric %s with err %+v", metric.ID, err)
+					unavailable.Store(true)
 				}
 			}
 		}()
 	}
 
-	// Reference https://stackoverflow.com/a/11104510
-LOOP:
-	// Начинаю работу над метриками
 	for _, metric := range metrics {
-		// Пока закидываю в канал jobs паралельно держу ухо востро, прилетит ли ошибка
-		select {
-		case <-errs:
-			// Если прилетела ошибка, закидывать в канал метрику смысла уже нет, весь бач метрик считаю испорченным
-			// и выхожу их цикла
-			break LOOP
-		default:
-			// Ну а если все хорошо идёт, ничего не остается, как работать
-			jobs <- metric
+		// There is no reason to keep requesting server that doesn't respond.
+		if unavailable.Load() || ctx.Err() != nil {
+			break
 		}
+		jobs <- metric
 	}
-
-	// Если мы дошли сюда, значит либо все метрики обработаны, либо пришла ошибка и мы вышли из цикла `range metrics`
 	close(jobs)
-	// Жду, когда горутины с запросами закончат работать
 	wg.Wait()
-	// После окончания всех запросов канал с ошибками моно смело закрывать
-	// так как уже некому паниковать, что отпавляет ошибку в закрытый канал
-	close(errs)
-
-	// Расчехляю канал ошибок
-	if err := <-errs; err != nil {
-		// Если была хоть одна ошибка, весь бач сичтаю испорченным и выхожу из функции
-		return
-	}
-
-	// Все прошло хорошо, можно счетчик сбросить
-	m.memStorage.ResetCounter()
 }
 
-func (m *MetricsClient) sendOneMetric(metric models.Metric) error {
-	req := newRestyClient().R()
-	req.SetHeader("Content-Type", "application/json")
-	req.URL = m.ServerAddr + "/update/"
-	req.Method = http.MethodPost
+func (m *MetricsClient) sendOneMetric(ctx context.Context, metric models.Metric) error {
+	if m.rpc != nil {
+		return m.sendGRPC(ctx, []models.Metric{metric})
+	}
 
 	marshalled, err := json.Marshal(metric)
 	if err != nil {
 		return err
 	}
 
-	if m.signKey != "" {
-		req.SetHeader("HashSHA256", sign.GetHmacString(marshalled, m.signKey))
-	}
+	return m.post(ctx, "/update/", marshalled)
+}
 
-	gzipped, err := compressor.Compress(marshalled)
-	if err != nil {
-		return err
+// SendBatchMetrics method send all metrics in one request.
+// Batches kept in queue are sent first in order they were collected. If server is unavailable or refused the agent,
+// current batch is put into the queue and its counter deltas are acknowledged, because the queue keeps them now.
+// Without queue the deltas stay pending. Only batches server judged invalid are dropped.
+func (m *MetricsClient) SendBatchMetrics(ctx context.Context) {
+	metrics := m.withLabels(m.memStorage.GetAllMetrics())
+
+	if m.queue != nil && !m.replayQueue(ctx) {
+		m.enqueue(metrics)
+		return
 	}
 
-	if _, err = req.SetBody(gzipped).Send(); err != nil {
-		return err
+	if len(metrics) == 0 {
+		return
 	}
 
-	return nil
+	err := m.sendBatch(ctx, metrics)
+	switch {
+	case err == nil:
+		m.memStorage.AckCounters(metrics)
+	case (errors.Is(err, errServerUnavailable) || errors.Is(err, errRefused)) && m.queue != nil:
+		log.Printf("Err sending metrics with err %+v", err)
+		m.enqueue(metrics)
+	case errors.Is(err, errRejected):
+		// Server won't accept these values on retry, they are dropped.
+		log.Printf("Err sending metrics with err %+v", err)
+		m.memStorage.AckCounters(metrics)
+	default:
+		log.Printf("Err sending metrics with err %+v", err)
+	}
 }
 
-// SendBatchMetrics method send all metrics in one request.
-func (m *MetricsClient) SendBatchMetrics() {
-	req := newRestyClient().R()
-	req.SetHeader("Content-Type", "application/json")
-	req.URL = m.ServerAddr + "/updates/"
-	req.Method = http.MethodPost
+// replayQueue sends queued batches until the queue is empty or server fails.
+func (m *MetricsClient) replayQueue(ctx context.Context) bool {
+	for {
+		batch, ok, err := m.queue.Peek()
+		if err != nil {
+			log.Printf("Err reading send queue with err %+v", err)
+			return false
+		}
+		if !ok {
+			return true
+		}
 
-	metrics := m.memStorage.GetAllMetrics()
+		err = m.sendBatch(ctx, batch.Metrics)
+		if errors.Is(err, errServerUnavailable) || errors.Is(err, errRefused) {
+			log.Printf("Err sending queued metrics with err %+v", err)
+			return false
+		}
+		if err != nil {
+			log.Printf("Err sending queued metrics collected at %s, batch is dropped: %+v", batch.Timestamp, err)
+		}
+
+		if err = m.queue.Ack(); err != nil {
+			log.Printf("Err updating send queue with err %+v", err)
+			return false
+		}
+	}
+}
+
+func (m *MetricsClient) enqueue(metrics []models.Metric) {
 	if len(metrics) == 0 {
 		return
 	}
 
+	if err := m.queue.Push(queue.Batch{Timestamp: time.Now(), Metrics: metrics}); err != nil {
+		log.Printf("Err putting metrics into send queue with err %+v", err)
+		return
+	}
+	m.memStorage.AckCounters(metrics)
+}
+
+func (m *MetricsClient) sendBatch(ctx context.Context, metrics []models.Metric) error {
+	if m.rpc != nil {
+		return m.sendGRPC(ctx, metrics)
+	}
+
 	marshalled, err := json.Marshal(metrics)
 	if err != nil {
-		log.Printf("Err JSON marshalling metrics with err %+v", err)
-		return
+		return err
 	}
 
+	return m.post(ctx, "/updates/", marshalled)
+}
+
+// post sends signed, compressed and optionally encrypted body to server. Returned error wraps errServerUnavailable
+// if request might succeed later, errRefused if server didn't accept the agent because of signature, clock skew
+// or trusted subnet, which might be fixed without losing metrics, and errRejected if server judged the body invalid.
+func (m *MetricsClient) post(ctx context.Context, path string, marshalled []byte) error {
+	restyClient := newRestyClient(m.tlsConfig)
 	if m.signKey != "" {
-		req.SetHeader("HashSHA256", sign.GetHmacString(marshalled, m.signKey))
+		// Every retry needs fresh timestamp and nonce, server rejects replayed requests.
+		restyClient.OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
+			return sign.SetRequestHeaders(r.Header, m.signKey, http.MethodPost, path, marshalled)
+		})
 	}
 
-	gzipped, err := compressor.Compress(marshalled)
+	req := restyClient.R().SetContext(ctx)
+	req.SetHeader("Content-Type", "application/json")
+	req.URL = m.ServerAddr + path
+	req.Method = http.MethodPost
+
+	if m.realIP != "" {
+		req.SetHeader(realIPHeader, m.realIP)
+	}
+	req.SetHeaders(m.identity())
+
+	body, err := compressor.Compress(marshalled)
 	if err != nil {
-		log.Printf("Err compressing metrics with err %+v", err)
-		return
+		return err
 	}
 
-	if _, err := req.SetBody(gzipped).Send(); err != nil {
-		log.Printf("Err sending metrics with err %+v", err)
-	} else {
-		m.memStorage.ResetCounter()
+	if m.publicKey != nil {
+		if body, err = encryptor.Encrypt(m.publicKey, body); err != nil {
+			return err
+		}
+		req.SetHeader(encryptor.HeaderName, encryptor.HeaderValue)
 	}
+
+	resp, err := req.SetBody(body).Send()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errServerUnavailable, err)
+	}
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: status %d", errServerUnavailable, resp.StatusCode())
+	}
+	if resp.StatusCode() == http.StatusUnauthorized || resp.StatusCode() == http.StatusForbidden {
+		return fmt.Errorf("%w: status %d: %s", errRefused, resp.StatusCode(), strings.TrimSpace(resp.String()))
+	}
+	if resp.IsError() {
+		return fmt.Errorf("%w: status %d", errRejected, resp.StatusCode())
+	}
+
+	return nil
 }
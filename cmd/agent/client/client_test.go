@@ -1,22 +1,37 @@
 package client
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/aykuli/observer/internal/agent/config"
+	"github.com/aykuli/observer/internal/agent/queue"
 	"github.com/aykuli/observer/internal/agent/storage"
+	"github.com/aykuli/observer/internal/compressor"
+	"github.com/aykuli/observer/internal/encryptor"
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/sign"
 )
 
 func TestSendBatchMetrics(t *testing.T) {
-	reqCounter := 0
+	var reqCounter atomic.Int32
 	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqCounter++
+		reqCounter.Add(1)
 	}))
 	defer testServer.Close()
 
@@ -33,15 +48,518 @@ func TestSendBatchMetrics(t *testing.T) {
 		RateLimit:      10,
 	}
 
-	client := NewMetricsClient(options, &memstorage)
+	client, err := NewMetricsClient(options, &memstorage)
+	require.NoError(t, err)
 
 	t.Run("sends batch", func(t *testing.T) {
-		client.SendBatchMetrics()
-		assert.Equal(t, 1, reqCounter)
+		client.SendBatchMetrics(context.Background())
+		assert.Equal(t, int32(1), reqCounter.Load())
 	})
 
 	t.Run("sends by one", func(t *testing.T) {
-		client.SendMetrics()
-		assert.Equal(t, len(memstorage.GetAllMetrics())+1, reqCounter)
+		client.SendMetrics(context.Background())
+		assert.Equal(t, int32(len(memstorage.GetAllMetrics())+1), reqCounter.Load())
 	})
 }
+
+func TestSendBatchMetricsQueue(t *testing.T) {
+	var serverDown atomic.Bool
+	var received [][]models.Metric
+	testServer := httptest.NewServer(compressor.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if serverDown.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var metrics []models.Metric
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&metrics))
+		received = append(received, metrics)
+	})))
+	defer testServer.Close()
+
+	configAddr, ok := strings.CutPrefix(testServer.URL, "http://")
+	require.True(t, ok)
+	options := config.Config{Address: configAddr, QueueDir: t.TempDir()}
+
+	memstorage := storage.NewMemStorage()
+	client, err := NewMetricsClient(options, &memstorage)
+	require.NoError(t, err)
+	require.NotNil(t, client.queue)
+
+	pollCount := func(metrics []models.Metric) int64 {
+		for _, mt := range metrics {
+			if mt.ID == "PollCount" {
+				return *mt.Delta
+			}
+		}
+		return -1
+	}
+
+	serverDown.Store(true)
+	memstorage.GarbageStats()
+	client.SendBatchMetrics(context.Background())
+	assert.Equal(t, int64(0), pollCount(memstorage.GetAllMetrics()), "queued counters are reset")
+
+	memstorage.GarbageStats()
+	memstorage.GarbageStats()
+	client.SendBatchMetrics(context.Background())
+	assert.False(t, client.queue.Empty())
+
+	serverDown.Store(false)
+	memstorage.GarbageStats()
+	client.SendBatchMetrics(context.Background())
+
+	require.Len(t, received, 3, "queued batches are replayed before the current one")
+	assert.Equal(t, []int64{1, 2, 1}, []int64{pollCount(received[0]), pollCount(received[1]), pollCount(received[2])})
+	assert.True(t, client.queue.Empty())
+}
+
+func TestRefusedMetricsAreKept(t *testing.T) {
+	var code atomic.Int32
+	var received []models.Metric
+	testServer := httptest.NewServer(compressor.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c := int(code.Load()); c != http.StatusOK {
+			w.WriteHeader(c)
+			return
+		}
+		var metrics []models.Metric
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&metrics))
+		received = append(received, metrics...)
+	})))
+	defer testServer.Close()
+
+	pending := func(memstorage *storage.MemStorage) int64 {
+		for _, mt := range memstorage.GetAllMetrics() {
+			if mt.ID == "hits" {
+				return *mt.Delta
+			}
+		}
+		return 0
+	}
+
+	t.Run("without queue", func(t *testing.T) {
+		memstorage := storage.NewMemStorage()
+		client, err := NewMetricsClient(config.Config{Address: testServer.URL}, &memstorage)
+		require.NoError(t, err)
+
+		memstorage.AddCounter("hits", 3)
+		code.Store(http.StatusUnauthorized)
+		client.SendBatchMetrics(context.Background())
+		assert.Equal(t, int64(3), pending(&memstorage), "clock skew or wrong key keeps deltas pending")
+
+		code.Store(http.StatusForbidden)
+		client.SendMetrics(context.Background())
+		assert.Equal(t, int64(3), pending(&memstorage), "agent out of trusted subnet keeps deltas pending")
+
+		code.Store(http.StatusBadRequest)
+		client.SendBatchMetrics(context.Background())
+		assert.Equal(t, int64(0), pending(&memstorage), "invalid body is dropped")
+	})
+
+	t.Run("with queue", func(t *testing.T) {
+		received = nil
+		memstorage := storage.NewMemStorage()
+		client, err := NewMetricsClient(config.Config{Address: testServer.URL, QueueDir: t.TempDir()}, &memstorage)
+		require.NoError(t, err)
+		defer client.Close()
+
+		memstorage.AddCounter("hits", 3)
+		code.Store(http.StatusUnauthorized)
+		client.SendBatchMetrics(context.Background())
+		assert.False(t, client.queue.Empty(), "refused batch is queued")
+		client.SendBatchMetrics(context.Background())
+		assert.False(t, client.queue.Empty(), "refused queued batch is kept")
+
+		code.Store(http.StatusOK)
+		client.SendBatchMetrics(context.Background())
+		assert.True(t, client.queue.Empty())
+		var total int64
+		for _, mt := range received {
+			total += *mt.Delta
+		}
+		assert.Equal(t, int64(3), total, "queued deltas are delivered once server accepts agent")
+	})
+}
+
+// counterServer sums counter deltas it accepted, requests for failing metrics get 500 status.
+type counterServer struct {
+	mu      sync.Mutex
+	totals  map[string]int64
+	failing map[string]bool
+}
+
+func (s *counterServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var metrics []models.Metric
+	if r.URL.Path == "/updates/" {
+		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else {
+		var metric models.Metric
+		if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		metrics = append(metrics, metric)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, mt := range metrics {
+		if s.failing[mt.ID] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, mt := range metrics {
+		if mt.MType == "counter" {
+			s.totals[mt.ID] += *mt.Delta
+		}
+	}
+}
+
+func TestCounterAcknowledgement(t *testing.T) {
+	sendModes := map[string]func(c *MetricsClient, ctx context.Context){
+		"by one": (*MetricsClient).SendMetrics,
+		"batch":  (*MetricsClient).SendBatchMetrics,
+	}
+
+	for mode, send := range sendModes {
+		t.Run(mode+" sending doesn't lose concurrent increments", func(t *testing.T) {
+			srv := &counterServer{totals: map[string]int64{}}
+			testServer := httptest.NewServer(compressor.GzipMiddleware(srv))
+			defer testServer.Close()
+
+			configAddr, ok := strings.CutPrefix(testServer.URL, "http://")
+			require.True(t, ok)
+			memstorage := storage.NewMemStorage()
+			client, err := NewMetricsClient(config.Config{Address: configAddr, RateLimit: 2}, &memstorage)
+			require.NoError(t, err)
+
+			const writers, increments = 4, 200
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < increments; i++ {
+						memstorage.AddCounter("hits", 1)
+						memstorage.AddCounter("misses", 2)
+					}
+				}()
+			}
+
+			stop := make(chan struct{})
+			sent := make(chan struct{})
+			go func() {
+				defer close(sent)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						send(client, context.Background())
+					}
+				}
+			}()
+
+			wg.Wait()
+			close(stop)
+			<-sent
+			send(client, context.Background())
+
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			assert.Equal(t, int64(writers*increments), srv.totals["hits"])
+			assert.Equal(t, int64(2*writers*increments), srv.totals["misses"])
+		})
+	}
+
+	t.Run("by one sending keeps only failed counters pending", func(t *testing.T) {
+		srv := &counterServer{totals: map[string]int64{}, failing: map[string]bool{"misses": true}}
+		testServer := httptest.NewServer(compressor.GzipMiddleware(srv))
+		defer testServer.Close()
+
+		configAddr, ok := strings.CutPrefix(testServer.URL, "http://")
+		require.True(t, ok)
+		memstorage := storage.NewMemStorage()
+		client, err := NewMetricsClient(config.Config{Address: configAddr, RateLimit: 1}, &memstorage)
+		require.NoError(t, err)
+
+		memstorage.AddCounter("hits", 3)
+		memstorage.AddCounter("misses", 5)
+		client.SendMetrics(context.Background())
+
+		pending := map[string]int64{}
+		for _, mt := range memstorage.GetAllMetrics() {
+			pending[mt.ID] = *mt.Delta
+		}
+		assert.Equal(t, int64(5), pending["misses"])
+		if srv.totals["hits"] == 3 {
+			assert.Equal(t, int64(0), pending["hits"])
+		} else {
+			// Sending stopped after server failure, metric is left for the next report.
+			assert.Equal(t, int64(3), pending["hits"])
+		}
+
+		srv.mu.Lock()
+		srv.failing = nil
+		srv.mu.Unlock()
+		client.SendMetrics(context.Background())
+		assert.Equal(t, int64(3), srv.totals["hits"])
+		assert.Equal(t, int64(5), srv.totals["misses"])
+	})
+}
+
+func TestSendBatchMetricsDeadline(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer testServer.Close()
+
+	configAddr, ok := strings.CutPrefix(testServer.URL, "http://")
+	require.True(t, ok)
+	memstorage := storage.NewMemStorage()
+	queueDir := t.TempDir()
+	client, err := NewMetricsClient(config.Config{Address: configAddr, QueueDir: queueDir}, &memstorage)
+	require.NoError(t, err)
+	require.NotNil(t, client.queue)
+	defer client.Close()
+
+	_, err = NewMetricsClient(config.Config{Address: configAddr, QueueDir: queueDir}, &memstorage)
+	assert.ErrorIs(t, err, queue.ErrLocked, "two agents can't share queue directory")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	memstorage.AddCounter("hits", 7)
+	client.SendBatchMetrics(ctx)
+
+	assert.False(t, client.queue.Empty(), "batch not sent before deadline is kept in queue")
+	batch, ok, err := client.queue.Peek()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Len(t, batch.Metrics, 1)
+	assert.Equal(t, int64(7), *batch.Metrics[0].Delta)
+}
+
+func TestSendEncryptedMetrics(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pkix, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}), 0o600))
+
+	var encrypted atomic.Bool
+	var received []models.Metric
+	decrypted := encryptor.DecryptMiddleware(privateKey)(compressor.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	})))
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encrypted.Store(r.Header.Get(encryptor.HeaderName) == encryptor.HeaderValue)
+		decrypted.ServeHTTP(w, r)
+	}))
+	defer testServer.Close()
+
+	configAddr, ok := strings.CutPrefix(testServer.URL, "http://")
+	require.True(t, ok)
+
+	t.Run("metrics are readable only with private key", func(t *testing.T) {
+		memstorage := storage.NewMemStorage()
+		client, err := NewMetricsClient(config.Config{Address: configAddr, CryptoKey: keyPath}, &memstorage)
+		require.NoError(t, err)
+
+		memstorage.AddCounter("hits", 2)
+		client.SendBatchMetrics(context.Background())
+
+		assert.True(t, encrypted.Load())
+		require.Len(t, received, 1)
+		assert.Equal(t, "hits", received[0].ID)
+		assert.Equal(t, int64(2), *received[0].Delta)
+	})
+
+	t.Run("client isn't created without key", func(t *testing.T) {
+		memstorage := storage.NewMemStorage()
+		_, err := NewMetricsClient(config.Config{Address: configAddr, CryptoKey: filepath.Join(t.TempDir(), "missing.pem")}, &memstorage)
+		assert.Error(t, err)
+	})
+}
+
+func TestSendMetricsTLS(t *testing.T) {
+	var reqCounter atomic.Int32
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCounter.Add(1)
+	}))
+	defer testServer.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testServer.Certificate().Raw}), 0o600))
+	configAddr, ok := strings.CutPrefix(testServer.URL, "https://")
+	require.True(t, ok)
+
+	memstorage := storage.NewMemStorage()
+	client, err := NewMetricsClient(config.Config{Address: configAddr, TLSCA: caFile}, &memstorage)
+	require.NoError(t, err)
+	assert.Equal(t, testServer.URL, client.ServerAddr)
+
+	memstorage.AddCounter("hits", 1)
+	client.SendBatchMetrics(context.Background())
+	assert.Equal(t, int32(1), reqCounter.Load())
+
+	t.Run("server isn't trusted without CA", func(t *testing.T) {
+		client, err := NewMetricsClient(config.Config{Address: testServer.URL}, &memstorage)
+		require.NoError(t, err)
+		memstorage.AddCounter("hits", 1)
+		client.SendBatchMetrics(context.Background())
+		assert.Equal(t, int32(1), reqCounter.Load())
+	})
+}
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		address string
+		useTLS  bool
+		want    string
+	}{
+		{address: "localhost:8080", want: "http://localhost:8080"},
+		{address: "localhost:8080", useTLS: true, want: "https://localhost:8080"},
+		{address: "https://metrics.example.com/", want: "https://metrics.example.com"},
+		{address: "http://localhost:8080", useTLS: true, want: "http://localhost:8080"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, serverURL(tt.address, tt.useTLS))
+	}
+}
+
+func TestRealIPHeader(t *testing.T) {
+	var realIP atomic.Value
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		realIP.Store(r.Header.Get(realIPHeader))
+	}))
+	defer testServer.Close()
+
+	memstorage := storage.NewMemStorage()
+	client, err := NewMetricsClient(config.Config{Address: testServer.URL}, &memstorage)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", client.realIP)
+
+	memstorage.AddCounter("hits", 1)
+	client.SendBatchMetrics(context.Background())
+	assert.Equal(t, "127.0.0.1", realIP.Load())
+}
+
+func TestHostPort(t *testing.T) {
+	assert.Equal(t, "localhost:8080", hostPort("http://localhost:8080"))
+	assert.Equal(t, "metrics.example.com:443", hostPort("https://metrics.example.com"))
+	assert.Equal(t, "metrics.example.com:80", hostPort("http://metrics.example.com"))
+}
+
+func TestSignedRequests(t *testing.T) {
+	key := "secret"
+	var attempts atomic.Int32
+	var nonces sync.Map
+	var received []models.Metric
+	verified := compressor.GzipMiddleware(sign.NewVerifier(key, 0).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	})))
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces.Store(r.Header.Get(sign.NonceHeader), true)
+		verified.ServeHTTP(w, r)
+	}))
+	defer testServer.Close()
+
+	memstorage := storage.NewMemStorage()
+	client, err := NewMetricsClient(config.Config{Address: testServer.URL, Key: key}, &memstorage)
+	require.NoError(t, err)
+
+	memstorage.AddCounter("hits", 3)
+	client.SendBatchMetrics(context.Background())
+
+	assert.Equal(t, int32(2), attempts.Load(), "retried request is signed again and accepted")
+	nonceCount := 0
+	nonces.Range(func(_, _ any) bool {
+		nonceCount++
+		return true
+	})
+	assert.Equal(t, 2, nonceCount)
+	require.Len(t, received, 1)
+	assert.Equal(t, int64(3), *received[0].Delta)
+}
+
+func TestAgentLabels(t *testing.T) {
+	var received []models.Metric
+	testServer := httptest.NewServer(compressor.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	})))
+	defer testServer.Close()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	memstorage := storage.NewMemStorage()
+	client, err := NewMetricsClient(config.Config{Address: testServer.URL, Labels: "service=api,env=prod"}, &memstorage)
+	require.NoError(t, err)
+
+	memstorage.AddCounter("hits", 3)
+	client.SendBatchMetrics(context.Background())
+
+	require.Len(t, received, 1)
+	assert.Equal(t, map[string]string{"service": "api", "env": "prod"}, received[0].Labels, "host label is opt-in")
+
+	t.Run("host label", func(t *testing.T) {
+		labels, err := agentLabels("service=api", true)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"service": "api", "host": hostname}, labels)
+	})
+
+	t.Run("configured host label is kept", func(t *testing.T) {
+		labels, err := agentLabels("host=web-1", true)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"host": "web-1"}, labels)
+	})
+
+	t.Run("no labels", func(t *testing.T) {
+		labels, err := agentLabels("", false)
+		require.NoError(t, err)
+		assert.Empty(t, labels)
+	})
+
+	t.Run("wrong labels", func(t *testing.T) {
+		_, err := NewMetricsClient(config.Config{Address: testServer.URL, Labels: "service"}, &memstorage)
+		assert.Error(t, err)
+	})
+}
+
+func TestAgentIdentity(t *testing.T) {
+	var header http.Header
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+	}))
+	defer testServer.Close()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	memstorage := storage.NewMemStorage()
+	memstorage.AddCounter("hits", 1)
+
+	client, err := NewMetricsClient(config.Config{Address: testServer.URL, ReportInterval: 10}, &memstorage)
+	require.NoError(t, err)
+	client.Version = "v1.2"
+	client.SendBatchMetrics(context.Background())
+
+	assert.Equal(t, hostname, header.Get(sign.AgentIDHeader), "hostname is default agent ID")
+	assert.Equal(t, "v1.2", header.Get(sign.AgentVersionHeader))
+	assert.Equal(t, "10", header.Get(sign.ReportIntervalHeader))
+
+	memstorage.AddCounter("hits", 1)
+	client, err = NewMetricsClient(config.Config{Address: testServer.URL, AgentID: "web-1"}, &memstorage)
+	require.NoError(t, err)
+	client.SendBatchMetrics(context.Background())
+
+	assert.Equal(t, "web-1", header.Get(sign.AgentIDHeader))
+	assert.Empty(t, header.Get(sign.AgentVersionHeader))
+}
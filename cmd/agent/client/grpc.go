@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/aykuli/observer/internal/models"
+	pb "github.com/aykuli/observer/internal/proto"
+	"github.com/aykuli/observer/internal/sign"
+)
+
+var errGRPCEncryption = errors.New("crypto key encryption isn't supported by gRPC transport, use TLS instead")
+
+// dialGRPC connects client to gRPC Metrics service. Connection is secured with client TLS settings if they are configured.
+func (m *MetricsClient) dialGRPC(address string) error {
+	creds := insecure.NewCredentials()
+	if m.tlsConfig != nil {
+		creds = credentials.NewTLS(m.tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+	m.rpc = pb.NewMetricsClient(conn)
+
+	return nil
+}
+
+// sendGRPC sends metrics batch to gRPC Metrics service. Request is gzipped and signed with agent identity,
+// the signature is sent in metadata with timestamp, nonce and identity like HTTP headers.
+func (m *MetricsClient) sendGRPC(ctx context.Context, metrics []models.Metric) error {
+	req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, len(metrics))}
+	for i, mt := range metrics {
+		metric, err := pb.FromModel(mt)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errRejected, err)
+		}
+		req.Metrics[i] = metric
+	}
+
+	identity := m.identity()
+	if m.signKey != "" {
+		body, err := pb.SignedBytes(req)
+		if err != nil {
+			return err
+		}
+		lookup := func(key string) string { return identity[key] }
+		timestamp, nonce, hash, err := sign.SignRequest(m.signKey, http.MethodPost, pb.Metrics_UpdateMetrics_FullMethodName, body, lookup)
+		if err != nil {
+			return err
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			sign.TimestampHeader, timestamp, sign.NonceHeader, nonce, pb.SignMetadataKey, hash)
+	}
+	if m.realIP != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, realIPHeader, m.realIP)
+	}
+	for k, v := range identity {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+
+	_, err := m.rpc.UpdateMetrics(ctx, req, grpc.UseCompressor(gzip.Name))
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return fmt.Errorf("%w: %v", errRefused, err)
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return fmt.Errorf("%w: %v", errRejected, err)
+	default:
+		return fmt.Errorf("%w: %v", errServerUnavailable, err)
+	}
+}
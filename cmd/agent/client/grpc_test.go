@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/aykuli/observer/internal/agent/config"
+	"github.com/aykuli/observer/internal/agent/storage"
+	pb "github.com/aykuli/observer/internal/proto"
+	"github.com/aykuli/observer/internal/sign"
+)
+
+type fakeMetricsServer struct {
+	pb.UnimplementedMetricsServer
+	mu       sync.Mutex
+	verifier *sign.Verifier
+	code     codes.Code
+	totals   map[string]int64
+	agentID  string
+}
+
+func (s *fakeMetricsServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.code != codes.OK {
+		return nil, status.Error(s.code, "test failure")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	body, err := pb.SignedBytes(req)
+	if err != nil {
+		return nil, err
+	}
+	first := func(key string) string {
+		if values := md.Get(key); len(values) == 1 {
+			return values[0]
+		}
+		return ""
+	}
+	err = s.verifier.Verify(http.MethodPost, pb.Metrics_UpdateMetrics_FullMethodName, body, first)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	s.agentID = first(sign.AgentIDHeader)
+	for _, m := range req.GetMetrics() {
+		s.totals[m.GetId()] += m.GetDelta()
+	}
+	return &pb.UpdateMetricsResponse{Metrics: req.GetMetrics()}, nil
+}
+
+func TestSendMetricsGRPC(t *testing.T) {
+	fake := &fakeMetricsServer{verifier: sign.NewVerifier("secret", 0), totals: map[string]int64{}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	pb.RegisterMetricsServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	memstorage := storage.NewMemStorage()
+	client, err := NewMetricsClient(config.Config{GRPCAddress: listener.Addr().String(), Key: "secret", QueueDir: t.TempDir(), AgentID: "web-1"}, &memstorage)
+	require.NoError(t, err)
+	defer client.Close()
+
+	pending := func() int64 {
+		for _, mt := range memstorage.GetAllMetrics() {
+			if mt.ID == "hits" {
+				return *mt.Delta
+			}
+		}
+		return -1
+	}
+
+	t.Run("batch is sent and acknowledged", func(t *testing.T) {
+		memstorage.AddCounter("hits", 3)
+		memstorage.SetGauge("temp", 1.5)
+		client.SendBatchMetrics(context.Background())
+
+		assert.Equal(t, int64(3), fake.totals["hits"])
+		assert.Equal(t, int64(0), pending())
+		assert.Equal(t, "web-1", fake.agentID)
+	})
+
+	t.Run("metrics are sent one by one", func(t *testing.T) {
+		client.limit = 2
+		defer func() { client.limit = 0 }()
+
+		memstorage.AddCounter("hits", 2)
+		client.SendMetrics(context.Background())
+
+		assert.Equal(t, int64(5), fake.totals["hits"])
+		assert.Equal(t, int64(0), pending())
+	})
+
+	t.Run("unavailable server", func(t *testing.T) {
+		fake.mu.Lock()
+		fake.code = codes.Unavailable
+		fake.mu.Unlock()
+
+		memstorage.AddCounter("hits", 4)
+		client.SendBatchMetrics(context.Background())
+		assert.False(t, client.queue.Empty())
+
+		fake.mu.Lock()
+		fake.code = codes.OK
+		fake.mu.Unlock()
+
+		client.SendBatchMetrics(context.Background())
+		assert.True(t, client.queue.Empty())
+		assert.Equal(t, int64(9), fake.totals["hits"])
+	})
+
+	t.Run("encryption requires HTTP transport", func(t *testing.T) {
+		_, err := NewMetricsClient(config.Config{GRPCAddress: listener.Addr().String(), CryptoKey: "public.pem"}, &memstorage)
+		assert.ErrorIs(t, err, errGRPCEncryption)
+	})
+}
@@ -2,18 +2,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/aykuli/observer/cmd/agent/client"
+	"github.com/aykuli/observer/cmd/agent/push"
 	"github.com/aykuli/observer/internal/agent/config"
 	"github.com/aykuli/observer/internal/agent/storage"
 	"github.com/aykuli/observer/internal/ldflags"
+	"github.com/aykuli/observer/internal/sign"
 )
 
+// ShutdownTimeout limits the final sending of metrics on agent stop.
+const ShutdownTimeout = 10 * time.Second
+
 var (
 	buildVersion string
 	buildDate    string
@@ -41,9 +51,21 @@ func main() {
 	}))
 
 	memStorage := storage.NewMemStorage()
-	newClient := client.NewMetricsClient(config.Options, &memStorage)
+	newClient, err := client.NewMetricsClient(config.Options, &memStorage)
+	if err != nil {
+		log.Fatalf("failed to create metrics client: %+v", err)
+	}
+	newClient.Version = buildVersion
+
+	registry := storage.NewRegistry(&memStorage)
+	registerCollectors(registry, config.Options)
+	log.Printf("registered collectors: %v", registry.Names())
 
-	collectTicker := time.NewTicker(time.Duration(config.Options.PollInterval) * time.Second)
+	collectInterval := registry.MinInterval()
+	if collectInterval <= 0 {
+		collectInterval = time.Duration(config.Options.PollInterval) * time.Second
+	}
+	collectTicker := time.NewTicker(collectInterval)
 	sendTicker := time.NewTicker(time.Duration(config.Options.ReportInterval) * time.Second)
 	defer collectTicker.Stop()
 	defer sendTicker.Stop()
@@ -54,17 +76,147 @@ func main() {
 		}
 	}()
 
+	var pushServer *http.Server
+	if config.Options.PushAddress != "" {
+		var verifier *sign.Verifier
+		if config.Options.Key != "" {
+			verifier = sign.NewVerifier(config.Options.Key, sign.SkewDefault)
+		}
+		pushServer = &http.Server{Addr: config.Options.PushAddress, Handler: push.Router(&memStorage, verifier)}
+		go func() {
+			if err := pushServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	send := func(ctx context.Context) {
+		if config.Options.RateLimit > 0 {
+			newClient.SendMetrics(ctx)
+		} else {
+			newClient.SendBatchMetrics(ctx)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	for {
 		select {
-		case <-collectTicker.C:
-			memStorage.GarbageStats()
-			memStorage.GetSystemUtilInfo()
+		case now := <-collectTicker.C:
+			registry.Collect(now)
 		case <-sendTicker.C:
-			if config.Options.RateLimit > 0 {
-				newClient.SendMetrics()
-			} else {
-				newClient.SendBatchMetrics()
+			send(ctx)
+		case <-ctx.Done():
+			log.Print("shutting down agent")
+			shutdown(registry, pushServer, send)
+			if err = newClient.Close(); err != nil {
+				log.Printf("closing metrics client failed: %+v", err)
+			}
+			return
+		}
+	}
+}
+
+// shutdown stops accepting pushed metrics, collects metrics the last time and sends them
+// not longer than ShutdownTimeout. Metrics failed to be sent are kept in send queue if it is configured.
+func shutdown(registry *storage.Registry, pushServer *http.Server, send func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if pushServer != nil {
+		if err := pushServer.Shutdown(ctx); err != nil {
+			log.Printf("push server shutdown failed: %+v", err)
+		}
+	}
+
+	// Exec collectors finish in background, their last metrics are waited for before they are killed.
+	registry.CollectAll()
+	if err := registry.Wait(ctx); err != nil {
+		log.Printf("waiting for collectors failed: %+v", err)
+	}
+	if err := registry.Close(); err != nil {
+		log.Printf("closing collectors failed: %+v", err)
+	}
+
+	send(ctx)
+}
+
+// registerCollectors adds enabled collectors to the registry with poll intervals from configuration.
+func registerCollectors(registry *storage.Registry, options config.Config) {
+	collectors := []storage.Collector{
+		storage.NewRuntimeCollector(),
+		storage.NewRuntimeMetricsCollector(options.RuntimeMetrics.Compat),
+		storage.NewSystemCollector(),
+		storage.NewCPUCollector(options.CPU.Mode != config.CPUModeAggregate, options.CPU.Mode != config.CPUModePerCore),
+		storage.NewDiskCollector(options.Disk.IncludeFsTypes, options.Disk.ExcludeFsTypes),
+		storage.NewNetworkCollector(),
+		storage.NewLoadCollector(),
+	}
+
+	if len(options.Processes) > 0 {
+		matchers := make([]storage.ProcessMatcher, len(options.Processes))
+		for i, p := range options.Processes {
+			matchers[i] = storage.ProcessMatcher{Name: p.Name, Process: p.Process, Cmdline: p.Cmdline, Pidfile: p.Pidfile}
+		}
+		if processCollector, err := storage.NewProcessCollector(matchers); err != nil {
+			log.Printf("process collector is not registered: %+v", err)
+		} else {
+			collectors = append(collectors, processCollector)
+		}
+	}
+
+	for _, e := range options.Exec {
+		execCollector, err := storage.NewExecCollector(e.Name, e.Command, time.Duration(e.Timeout)*time.Second)
+		if err != nil {
+			log.Printf("exec collector is not registered: %+v", err)
+			continue
+		}
+		collectors = append(collectors, execCollector)
+	}
+
+	if len(options.Logs) > 0 {
+		files := make([]storage.LogFile, len(options.Logs))
+		for i, l := range options.Logs {
+			files[i] = storage.LogFile{Path: l.Path}
+			for _, p := range l.Patterns {
+				files[i].Patterns = append(files[i].Patterns, storage.LogPattern{Counter: p.Counter, Regex: p.Regex, Gauge: p.Gauge, Group: p.Group})
+			}
+		}
+		if logTailCollector, err := storage.NewLogTailCollector(files, options.LogState); err != nil {
+			log.Printf("logtail collector is not registered: %+v", err)
+		} else {
+			collectors = append(collectors, logTailCollector)
+		}
+	}
+
+	// StatsD sockets are bound only if collector is enabled, so disabled one doesn't hold its ports.
+	if enabled, _ := options.CollectorSettings("statsd"); enabled && (options.StatsD.UDP != "" || options.StatsD.Unix != "") {
+		statsdCollector := storage.NewStatsDCollector(options.StatsD.UDP, options.StatsD.Unix)
+		if err := statsdCollector.Listen(); err != nil {
+			log.Printf("statsd collector is not registered: %+v", err)
+		} else {
+			collectors = append(collectors, statsdCollector)
+		}
+	}
+
+	cgroupRoot := options.CgroupRoot
+	if cgroupRoot == "" {
+		cgroupRoot = storage.CgroupRootDefault
+	}
+	if cgroupDir, err := storage.DetectCgroupDir(cgroupRoot, "/proc/self/cgroup", storage.InContainer("/")); err == nil {
+		collectors = append(collectors, storage.NewCgroupCollector(cgroupDir))
+	}
+
+	for _, c := range collectors {
+		enabled, interval := options.CollectorSettings(c.Name())
+		if !enabled {
+			// Disabled collectors are not registered, so registry won't close their files.
+			if closer, ok := c.(io.Closer); ok {
+				closer.Close()
 			}
+			continue
 		}
+		registry.Register(c, interval)
 	}
 }
@@ -0,0 +1,141 @@
+// Package push provides local HTTP endpoint for applications to push metrics to the agent.
+// Endpoints and payloads are the same as Observer server accepts, except labels which are rejected,
+// agent attaches its own labels. Metrics are sent to the server on the agent report cycle.
+package push
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/aykuli/observer/internal/agent/storage"
+	"github.com/aykuli/observer/internal/compressor"
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/sign"
+)
+
+// API struct keeps agent storage pushed metrics are saved to.
+type API struct {
+	MemStorage *storage.MemStorage
+}
+
+// Router creates endpoints mirroring server update API. If verifier is provided, requests must be signed
+// the same way server expects: over timestamp, nonce, method, path with query and decompressed body.
+func Router(memStorage *storage.MemStorage, verifier *sign.Verifier) chi.Router {
+	r := chi.NewRouter()
+	r.Use(compressor.GzipMiddleware)
+	r.Use(middleware.AllowContentEncoding("gzip"))
+	r.Use(middleware.AllowContentType("application/json", "text/html", "html/text", "text/plain"))
+	r.Use(verifier.Middleware)
+
+	api := API{MemStorage: memStorage}
+
+	r.Route("/update", func(r chi.Router) {
+		r.Post("/", api.UpdateFromJSON())
+		r.Post("/{metricType}/{metricName}/{metricValue}", api.Update())
+	})
+	r.Post("/updates/", api.BatchUpdate())
+
+	return r
+}
+
+// UpdateFromJSON saves one metric provided in JSON body.
+func (a *API) UpdateFromJSON() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var metric models.Metric
+		if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := a.MemStorage.SaveMetrics([]models.Metric{metric}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, metric)
+	}
+}
+
+// Update saves metric provided in URL path.
+func (a *API) Update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := chi.URLParam(r, "metricType")
+		metricName := chi.URLParam(r, "metricName")
+		metricValue := chi.URLParam(r, "metricValue")
+
+		if metricName == "" {
+			http.Error(w, "Metric name is empty", http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Has("labels") {
+			http.Error(w, "Metric labels aren't accepted by agent", http.StatusBadRequest)
+			return
+		}
+
+		var metric = models.Metric{ID: metricName, MType: metricType}
+
+		switch metricType {
+		case "gauge":
+			value, err := strconv.ParseFloat(metricValue, 64)
+			if err != nil {
+				http.Error(w, "Metric value is wrong", http.StatusBadRequest)
+				return
+			}
+			metric.Value = &value
+		case "counter":
+			delta, err := strconv.ParseInt(metricValue, 10, 64)
+			if err != nil {
+				http.Error(w, "Metric value is wrong", http.StatusBadRequest)
+				return
+			}
+			metric.Delta = &delta
+		default:
+			http.Error(w, "Metric type is wrong", http.StatusBadRequest)
+			return
+		}
+
+		if err := a.MemStorage.SaveMetrics([]models.Metric{metric}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, metric)
+	}
+}
+
+// BatchUpdate saves metrics array provided in JSON body. Batch is saved entirely or not saved at all.
+func (a *API) BatchUpdate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var metrics []models.Metric
+		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := a.MemStorage.SaveMetrics(metrics); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, metrics)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(data); err != nil {
+		log.Printf("push response writing error %+v", err)
+	}
+}
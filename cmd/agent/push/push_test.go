@@ -0,0 +1,105 @@
+package push
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aykuli/observer/internal/agent/storage"
+	"github.com/aykuli/observer/internal/compressor"
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/sign"
+)
+
+func TestRouter(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	ts := httptest.NewServer(Router(&memStorage, sign.NewVerifier("secret", 0)))
+	defer ts.Close()
+
+	post := func(t *testing.T, url string, body []byte, key string, gzipped bool) (int, string) {
+		reqBody := body
+		if gzipped {
+			var err error
+			reqBody, err = compressor.Compress(body)
+			require.NoError(t, err)
+		}
+		req, err := http.NewRequest(http.MethodPost, ts.URL+url, bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", "")
+		req.Header.Set("Content-Type", "application/json")
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		if key != "" {
+			require.NoError(t, sign.SetRequestHeaders(req.Header, key, http.MethodPost, url, body))
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(respBody)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		code int
+	}{
+		{name: "gauge", url: "/update/gauge/app.queue/5.5", code: http.StatusOK},
+		{name: "counter", url: "/update/counter/app.requests/3", code: http.StatusOK},
+		{name: "wrong type", url: "/update/histogram/app.requests/3", code: http.StatusBadRequest},
+		{name: "wrong counter value", url: "/update/counter/app.requests/3.5", code: http.StatusBadRequest},
+		{name: "no name", url: "/update/gauge", code: http.StatusNotFound},
+		{name: "labels", url: "/update/counter/app.requests/100?labels=path=/", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := post(t, tt.url, nil, "secret", false)
+			assert.Equal(t, tt.code, code)
+		})
+	}
+
+	t.Run("signed gzipped batch", func(t *testing.T) {
+		body := []byte(`[{"id":"app.requests","type":"counter","delta":2},{"id":"app.latency","type":"gauge","value":0.25}]`)
+		code, respBody := post(t, "/updates/", body, "secret", true)
+		require.Equal(t, http.StatusOK, code, respBody)
+	})
+
+	t.Run("wrong sign", func(t *testing.T) {
+		body := []byte(`{"id":"app.requests","type":"counter","delta":100}`)
+		code, _ := post(t, "/update/", body, "another", false)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("unsigned update", func(t *testing.T) {
+		code, _ := post(t, "/update/counter/app.requests/100", nil, "", false)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("labelled metrics are rejected", func(t *testing.T) {
+		body := []byte(`[{"id":"app.requests","type":"counter","delta":100,"labels":{"path":"/"}}]`)
+		code, _ := post(t, "/updates/", body, "secret", false)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("invalid batch is not saved", func(t *testing.T) {
+		code, _ := post(t, "/updates/", []byte(`[{"id":"app.requests","type":"counter","delta":100},{"id":"broken","type":"gauge"}]`), "secret", false)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	metrics := map[string]models.Metric{}
+	for _, mt := range memStorage.GetAllMetrics() {
+		metrics[mt.ID] = mt
+	}
+	assert.Equal(t, 5.5, *metrics["app.queue"].Value)
+	assert.Equal(t, 0.25, *metrics["app.latency"].Value)
+	assert.Equal(t, int64(5), *metrics["app.requests"].Delta)
+}
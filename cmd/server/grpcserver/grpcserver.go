@@ -0,0 +1,226 @@
+// Package grpcserver provides gRPC Metrics service backed by the same storage as HTTP handlers.
+package grpcserver
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // registers gzip decompressor for agent requests
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/aykuli/observer/internal/models"
+	pb "github.com/aykuli/observer/internal/proto"
+	"github.com/aykuli/observer/internal/server/agents"
+	"github.com/aykuli/observer/internal/server/storage"
+	"github.com/aykuli/observer/internal/server/subnet"
+	"github.com/aykuli/observer/internal/sign"
+)
+
+// MetricsServer struct keeps storage and implements gRPC Metrics service.
+// Agents registry records agents sending updates, it might be nil.
+type MetricsServer struct {
+	pb.UnimplementedMetricsServer
+	Storage storage.Storage
+	Logger  zap.SugaredLogger
+	Agents  *agents.Registry
+}
+
+// Options struct keeps optional server settings: key to sign responses, verifier of update request signatures,
+// trusted subnet of agents allowed to update metrics and registry of agents sending updates.
+type Options struct {
+	Key           string
+	Verifier      *sign.Verifier
+	TrustedSubnet *subnet.Checker
+	Agents        *agents.Registry
+}
+
+// NewServer creates gRPC server with registered Metrics service. Requests are logged,
+// update request signatures are verified with verifier, responses are signed with key.
+// Updates from agents outside trusted subnet are forbidden, agents sending updates are recorded in registry.
+func NewServer(storage storage.Storage, logger zap.SugaredLogger, options Options, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(
+		loggingInterceptor(logger),
+		subnetInterceptor(options.TrustedSubnet),
+		verifyInterceptor(options.Verifier),
+		signInterceptor(options.Key),
+	))
+	s := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(s, &MetricsServer{Storage: storage, Logger: logger, Agents: options.Agents})
+
+	return s
+}
+
+// UpdateMetrics saves metrics batch and returns their values kept in storage.
+func (s *MetricsServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if len(req.GetMetrics()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no metrics provided")
+	}
+
+	metrics := make([]models.Metric, len(req.GetMetrics()))
+	for i, m := range req.GetMetrics() {
+		metric, err := pb.ToModel(m)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err = models.ValidateSeries(metric.ID, metric.Labels); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		metrics[i] = metric
+	}
+
+	saved, err := s.Storage.SaveBatch(ctx, metrics)
+	if err != nil {
+		s.Logger.Errorln("cannot save metrics", zap.Error(err))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.agentSeen(ctx, metrics)
+	slices.SortFunc(saved, func(a, b models.Metric) int {
+		return cmp.Compare(models.SeriesName(a.ID, a.Labels), models.SeriesName(b.ID, b.Labels))
+	})
+
+	resp := &pb.UpdateMetricsResponse{Metrics: make([]*pb.Metric, 0, len(saved))}
+	for _, m := range saved {
+		metric, err := pb.FromModel(m)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Metrics = append(resp.Metrics, metric)
+	}
+
+	return resp, nil
+}
+
+// GetMetric returns current metric value.
+func (s *MetricsServer) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	mType := pb.TypeName(req.GetType())
+	if req.GetId() == "" || mType == "" {
+		return nil, status.Error(codes.InvalidArgument, "metric id and type should be provided")
+	}
+	if err := models.ValidateSeries(req.GetId(), req.GetLabels()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	m, err := s.Storage.ReadMetric(ctx, req.GetId(), mType, req.GetLabels())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "no such metric")
+	}
+
+	metric, err := pb.FromModel(*m)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.GetMetricResponse{Metric: metric}, nil
+}
+
+// agentSeen records agent sent saved metrics if agent ID is sent in metadata.
+func (s *MetricsServer) agentSeen(ctx context.Context, metrics []models.Metric) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+
+	lookup := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	if info, ok := agents.InfoFrom(lookup, remoteAddr); ok {
+		s.Agents.Seen(info, metrics)
+	}
+}
+
+func loggingInterceptor(logger zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		logger.Infoln("gRPC", info.FullMethod, status.Code(err))
+		return resp, err
+	}
+}
+
+// subnetInterceptor checks x-real-ip metadata or peer address of update requests like HTTP API does.
+func subnetInterceptor(trusted *subnet.Checker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if trusted == nil || info.FullMethod != pb.Metrics_UpdateMetrics_FullMethodName {
+			return handler(ctx, req)
+		}
+
+		var realIP, remoteAddr string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(subnet.RealIPHeader); len(values) > 0 {
+				realIP = values[0]
+			}
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			remoteAddr = p.Addr.String()
+		}
+
+		if !trusted.Allowed(realIP, remoteAddr) {
+			return nil, status.Error(codes.PermissionDenied, "agent is not in trusted subnet")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// verifyInterceptor verifies signature, timestamp and nonce of update requests sent in metadata like
+// HTTP API does. Signature covers timestamp, nonce, POST method, full gRPC method name, agent identity metadata
+// and deterministically marshalled request. If verifier is nil, requests are passed as they are.
+func verifyInterceptor(verifier *sign.Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if verifier == nil || info.FullMethod != pb.Metrics_UpdateMetrics_FullMethodName {
+			return handler(ctx, req)
+		}
+
+		msg, ok := req.(protobuf.Message)
+		if !ok {
+			return nil, status.Error(codes.Internal, "unexpected request type")
+		}
+		body, err := pb.SignedBytes(msg)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		lookup := func(key string) string {
+			if values := md.Get(key); len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		}
+		err = verifier.Verify(http.MethodPost, info.FullMethod, body, lookup)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "cannot serve this agent: "+err.Error())
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// signInterceptor signs responses with key, the signature is sent in metadata like HashSHA256 header of HTTP API.
+func signInterceptor(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		if err != nil || key == "" {
+			return resp, err
+		}
+
+		if respMsg, ok := resp.(protobuf.Message); ok {
+			if respBody, err := pb.SignedBytes(respMsg); err == nil {
+				_ = grpc.SetHeader(ctx, metadata.Pairs(pb.SignMetadataKey, sign.GetHmacString(respBody, key)))
+			}
+		}
+
+		return resp, nil
+	}
+}
@@ -0,0 +1,232 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/aykuli/observer/internal/proto"
+	"github.com/aykuli/observer/internal/server/agents"
+	"github.com/aykuli/observer/internal/server/config"
+	"github.com/aykuli/observer/internal/server/storage/local"
+	"github.com/aykuli/observer/internal/server/subnet"
+	"github.com/aykuli/observer/internal/sign"
+)
+
+func newTestClient(t *testing.T, key string, trusted *subnet.Checker, registry *agents.Registry) pb.MetricsClient {
+	t.Helper()
+	logger := zap.NewExample()
+	sugar := *logger.Sugar()
+	store, err := local.NewStorage(config.Config{}, sugar)
+	require.NoError(t, err)
+
+	listener := bufconn.Listen(1024 * 1024)
+	options := Options{Key: key, TrustedSubnet: trusted, Agents: registry}
+	if key != "" {
+		options.Verifier = sign.NewVerifier(key, 0)
+	}
+	server := NewServer(store, sugar, options)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricsClient(conn)
+}
+
+func TestMetricsServer(t *testing.T) {
+	client := newTestClient(t, "", nil, nil)
+	ctx := context.Background()
+
+	t.Run("update metrics", func(t *testing.T) {
+		req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+			{Id: "temp", Type: pb.Metric_GAUGE, Value: 36.6},
+			{Id: "hits", Type: pb.Metric_COUNTER, Delta: 2},
+		}}
+		resp, err := client.UpdateMetrics(ctx, req, grpc.UseCompressor(gzip.Name))
+		require.NoError(t, err)
+		require.Len(t, resp.GetMetrics(), 2)
+		assert.Equal(t, "hits", resp.GetMetrics()[0].GetId())
+
+		req = &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "hits", Type: pb.Metric_COUNTER, Delta: 3}}}
+		resp, err = client.UpdateMetrics(ctx, req, grpc.UseCompressor(gzip.Name))
+		require.NoError(t, err)
+		require.Len(t, resp.GetMetrics(), 1)
+		assert.Equal(t, int64(5), resp.GetMetrics()[0].GetDelta())
+	})
+
+	t.Run("get metric", func(t *testing.T) {
+		resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "temp", Type: pb.Metric_GAUGE})
+		require.NoError(t, err)
+		assert.Equal(t, 36.6, resp.GetMetric().GetValue())
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "unknown", Type: pb.Metric_COUNTER})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("labels", func(t *testing.T) {
+		labels := map[string]string{"host": "a", "service": "api"}
+		req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "temp", Type: pb.Metric_GAUGE, Value: 20, Labels: labels}}}
+		resp, err := client.UpdateMetrics(ctx, req, grpc.UseCompressor(gzip.Name))
+		require.NoError(t, err)
+		require.Len(t, resp.GetMetrics(), 1)
+		assert.Equal(t, labels, resp.GetMetrics()[0].GetLabels())
+
+		got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "temp", Type: pb.Metric_GAUGE, Labels: labels})
+		require.NoError(t, err)
+		assert.Equal(t, float64(20), got.GetMetric().GetValue())
+
+		got, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "temp", Type: pb.Metric_GAUGE})
+		require.NoError(t, err)
+		assert.Equal(t, 36.6, got.GetMetric().GetValue(), "metric without labels is another metric")
+
+		req = &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "temp", Type: pb.Metric_GAUGE, Labels: map[string]string{"host": "{a}"}}}}
+		_, err = client.UpdateMetrics(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		req = &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "temp{host=a,service=api}", Type: pb.Metric_GAUGE, Value: 1}}}
+		_, err = client.UpdateMetrics(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "series name as metric name")
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "temp{host=a,service=api}", Type: pb.Metric_GAUGE})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("wrong requests", func(t *testing.T) {
+		_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "untyped"}}})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "temp"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestMetricsServerSign(t *testing.T) {
+	key := "secret"
+	client := newTestClient(t, key, nil, nil)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "hits", Type: pb.Metric_COUNTER, Delta: 1}}}
+	body, err := pb.SignedBytes(req)
+	require.NoError(t, err)
+
+	// Request is signed by agent web-1 and sent with agentID in metadata.
+	signedAs := func(key, agentID string) context.Context {
+		identity := map[string]string{sign.AgentIDHeader: "web-1"}
+		timestamp, nonce, hash, err := sign.SignRequest(key, http.MethodPost, pb.Metrics_UpdateMetrics_FullMethodName, body,
+			func(key string) string { return identity[key] })
+		require.NoError(t, err)
+		return metadata.AppendToOutgoingContext(context.Background(), sign.AgentIDHeader, agentID,
+			sign.TimestampHeader, timestamp, sign.NonceHeader, nonce, pb.SignMetadataKey, hash)
+	}
+	signed := func(key string) context.Context { return signedAs(key, "web-1") }
+
+	t.Run("signed request", func(t *testing.T) {
+		var header metadata.MD
+		resp, err := client.UpdateMetrics(signed(key), req, grpc.Header(&header))
+		require.NoError(t, err)
+
+		respBody, err := pb.SignedBytes(resp)
+		require.NoError(t, err)
+		require.Len(t, header.Get(pb.SignMetadataKey), 1)
+		assert.True(t, sign.VerifyBytes(respBody, key, header.Get(pb.SignMetadataKey)[0]))
+	})
+
+	t.Run("request signed with other key", func(t *testing.T) {
+		_, err := client.UpdateMetrics(signed("other"), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("unsigned request", func(t *testing.T) {
+		_, err := client.UpdateMetrics(context.Background(), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("replayed request", func(t *testing.T) {
+		ctx := signed(key)
+		_, err := client.UpdateMetrics(ctx, req)
+		require.NoError(t, err)
+		_, err = client.UpdateMetrics(ctx, req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("forged agent ID", func(t *testing.T) {
+		_, err := client.UpdateMetrics(signedAs(key, "web-2"), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("old signature without timestamp and nonce", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), pb.SignMetadataKey, sign.GetHmacString(body, key))
+		_, err := client.UpdateMetrics(ctx, req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("reading isn't signed", func(t *testing.T) {
+		_, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "hits", Type: pb.Metric_COUNTER})
+		assert.NoError(t, err)
+	})
+}
+
+func TestMetricsServerTrustedSubnet(t *testing.T) {
+	trusted, err := subnet.New("192.168.1.0/24", false)
+	require.NoError(t, err)
+	client := newTestClient(t, "", trusted, nil)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "hits", Type: pb.Metric_COUNTER, Delta: 1}}}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), subnet.RealIPHeader, "192.168.1.10")
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.NoError(t, err)
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), subnet.RealIPHeader, "10.0.0.1")
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "hits", Type: pb.Metric_COUNTER})
+	assert.NoError(t, err, "reading is allowed")
+}
+
+func TestMetricsServerAgents(t *testing.T) {
+	registry := agents.NewRegistry(3)
+	client := newTestClient(t, "", nil, registry)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "hits", Type: pb.Metric_COUNTER, Delta: 1},
+		{Id: "temp", Type: pb.Metric_GAUGE, Value: 36.6},
+	}}
+
+	_, err := client.UpdateMetrics(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, registry.List(), "request without agent ID")
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		agents.IDHeader, "web-1", agents.VersionHeader, "v1.2", agents.ReportIntervalHeader, "30", subnet.RealIPHeader, "192.168.1.10")
+	_, err = client.UpdateMetrics(ctx, req)
+	require.NoError(t, err)
+
+	list := registry.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, "web-1", list[0].ID)
+	assert.Equal(t, "v1.2", list[0].Version)
+	assert.Equal(t, "192.168.1.10", list[0].IP)
+	assert.Equal(t, 30, list[0].ReportInterval)
+	assert.Equal(t, 2, list[0].Metrics)
+	assert.False(t, list[0].Stale)
+}
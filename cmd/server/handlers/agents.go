@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/agents"
+)
+
+// ListAgents godoc
+//
+//	@Produce		application/json
+//	@Success		200		{array}	agents.Agent	"OK"
+//	@Router			/api/v1/agents [GET]
+func (v *APIV1) ListAgents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(v.Agents.List()); err != nil {
+			v.Logger.Errorln("cannot encode agents", zap.Error(err))
+		}
+	}
+}
+
+// agentSeen records agent sent saved metrics if request has agent ID header.
+func (v *APIV1) agentSeen(r *http.Request, metrics ...models.Metric) {
+	if info, ok := agents.InfoFrom(r.Header.Get, r.RemoteAddr); ok {
+		v.Agents.Seen(info, metrics)
+	}
+}
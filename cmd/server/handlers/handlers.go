@@ -4,7 +4,6 @@ package handlers
 import (
 	"cmp"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"slices"
@@ -14,15 +13,18 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/agents"
 	"github.com/aykuli/observer/internal/server/config"
 	"github.com/aykuli/observer/internal/server/storage"
 	"github.com/aykuli/observer/internal/sign"
 )
 
-// APIV1 struct keeps storage struct and provides methods for endpoints routing
+// APIV1 struct keeps storage struct and provides methods for endpoints routing.
+// Agents registry records agents sending updates, it might be nil.
 type APIV1 struct {
 	Storage storage.Storage
 	Logger  zap.SugaredLogger
+	Agents  *agents.Registry
 }
 
 // Ping godoc
@@ -94,8 +96,12 @@ func (v *APIV1) ReadMetric() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
+		if err := models.ValidateSeries(askedMetric.ID, askedMetric.Labels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		metric, err := v.Storage.ReadMetric(r.Context(), askedMetric.ID, askedMetric.MType)
+		metric, err := v.Storage.ReadMetric(r.Context(), askedMetric.ID, askedMetric.MType, askedMetric.Labels)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
@@ -124,7 +130,16 @@ func (v *APIV1) GetMetric() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		mType := chi.URLParam(r, "metricType")
 		mName := chi.URLParam(r, "metricName")
-		metric, err := v.Storage.ReadMetric(r.Context(), mName, mType)
+		labels, err := models.ParseLabels(r.URL.Query().Get("labels"))
+		if err == nil {
+			err = models.ValidateSeries(mName, labels)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		metric, err := v.Storage.ReadMetric(r.Context(), mName, mType, labels)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
@@ -165,11 +180,8 @@ func (v *APIV1) UpdateFromJSON() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		equal := sign.Verify(metric, config.Options.Key, r.Header.Get("HashSHA256"))
-		if !equal {
-			v.Logger.Errorln("signs not equal", zap.Error(errors.New("signs not equal")))
-			http.Error(w, "cannot serve this agent", http.StatusBadRequest)
+		if err := models.ValidateSeries(metric.ID, metric.Labels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -178,6 +190,7 @@ func (v *APIV1) UpdateFromJSON() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
+		v.agentSeen(r, metric)
 
 		byteData, err := json.Marshal(outMetric)
 		if err != nil {
@@ -224,7 +237,16 @@ func (v *APIV1) Update() http.HandlerFunc {
 			return
 		}
 
-		var metric = models.Metric{ID: metricName, MType: metricType}
+		labels, err := models.ParseLabels(r.URL.Query().Get("labels"))
+		if err == nil {
+			err = models.ValidateSeries(metricName, labels)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var metric = models.Metric{ID: metricName, MType: metricType, Labels: labels}
 
 		switch metricType {
 		case "gauge":
@@ -251,6 +273,7 @@ func (v *APIV1) Update() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
+		v.agentSeen(r, metric)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -278,12 +301,11 @@ func (v *APIV1) BatchUpdate() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		equal := sign.Verify(metrics, config.Options.Key, r.Header.Get("HashSHA256"))
-		if !equal {
-			v.Logger.Errorln("signs not equal", zap.Error(errors.New("signs not equal")))
-			http.Error(w, "cannot serve this agent", http.StatusBadRequest)
-			return
+		for _, m := range metrics {
+			if err := models.ValidateSeries(m.ID, m.Labels); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 		}
 
 		outMetrics, err := v.Storage.SaveBatch(r.Context(), metrics)
@@ -291,8 +313,9 @@ func (v *APIV1) BatchUpdate() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		v.agentSeen(r, metrics...)
 		slices.SortFunc(outMetrics, func(a, b models.Metric) int {
-			return cmp.Compare(a.ID, b.ID)
+			return cmp.Compare(models.SeriesName(a.ID, a.Labels), models.SeriesName(b.ID, b.Labels))
 		})
 
 		body, err := json.Marshal(outMetrics)
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/query"
+)
+
+// Range query defaults
+const (
+	queryRangeDefault = time.Hour
+	queryStepDefault  = time.Minute
+)
+
+// QueryRange godoc
+//
+//	@Produce		application/json
+//	@Param			id		query		string	true	"metric name"
+//	@Param			type	query		string	true	"metric type: gauge or counter"
+//	@Param			labels	query		string	false	"metric labels like host=a,service=b"
+//	@Param			from	query		string	false	"range start, RFC3339 or unix seconds, one hour before `to` by default"
+//	@Param			to		query		string	false	"range end, RFC3339 or unix seconds, now by default"
+//	@Param			step	query		string	false	"bucket size, duration like 30s or seconds, 1m by default"
+//	@Param			agg		query		string	false	"avg, min, max, sum, count, last, p50, p95, p99, rate or increase, avg by default"
+//	@Success		200		{object}	query.Result	"OK"
+//	@Failure		400		{string}	error	"Bad Request"
+//	@Failure		500		{string}	error	"Internal Server Error"
+//	@Router			/api/v1/query_range [GET]
+func (v *APIV1) QueryRange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseQueryRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = req.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := query.Run(r.Context(), v.Storage, req)
+		if err != nil {
+			v.Logger.Errorln("cannot query metric samples", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err = json.NewEncoder(w).Encode(result); err != nil {
+			v.Logger.Errorln("cannot encode query result", zap.Error(err))
+		}
+	}
+}
+
+func parseQueryRange(r *http.Request) (query.Request, error) {
+	params := r.URL.Query()
+	req := query.Request{
+		ID:          params.Get("id"),
+		MType:       params.Get("type"),
+		To:          time.Now(),
+		Step:        queryStepDefault,
+		Aggregation: params.Get("agg"),
+	}
+	if req.ID == "" || (req.MType != "gauge" && req.MType != "counter") {
+		return req, errors.New("metric id and type gauge or counter should be provided")
+	}
+	if req.Aggregation == "" {
+		req.Aggregation = query.Avg
+	}
+
+	var err error
+	if req.Labels, err = models.ParseLabels(params.Get("labels")); err != nil {
+		return req, err
+	}
+	if err = models.ValidateSeries(req.ID, req.Labels); err != nil {
+		return req, err
+	}
+	if to := params.Get("to"); to != "" {
+		if req.To, err = parseQueryTime(to); err != nil {
+			return req, fmt.Errorf("wrong to: %w", err)
+		}
+	}
+	req.From = req.To.Add(-queryRangeDefault)
+	if from := params.Get("from"); from != "" {
+		if req.From, err = parseQueryTime(from); err != nil {
+			return req, fmt.Errorf("wrong from: %w", err)
+		}
+	}
+	if step := params.Get("step"); step != "" {
+		if req.Step, err = parseQueryStep(step); err != nil {
+			return req, fmt.Errorf("wrong step: %w", err)
+		}
+	}
+
+	return req, nil
+}
+
+// parseQueryTime accepts RFC3339 time or unix seconds with optional fraction.
+func parseQueryTime(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// parseQueryStep accepts duration like 30s or number of seconds.
+func parseQueryStep(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/config"
+	"github.com/aykuli/observer/internal/server/query"
+	"github.com/aykuli/observer/internal/server/storage/local"
+)
+
+func TestQueryRange(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := *logger.Sugar()
+
+	store, err := local.NewStorage(config.Config{}, sugar)
+	require.NoError(t, err)
+	for _, v := range []float64{1, 2, 6} {
+		value := v
+		_, err = store.SaveMetric(context.Background(), models.Metric{ID: "HeapAlloc", MType: "gauge", Value: &value})
+		require.NoError(t, err)
+	}
+
+	api := APIV1{Storage: store, Logger: sugar}
+	ts := httptest.NewServer(api.QueryRange())
+	defer ts.Close()
+
+	now := time.Now()
+	from := strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)
+	to := now.Add(time.Minute).Format(time.RFC3339)
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		count int
+	}{
+		{name: "max with duration step", query: "?id=HeapAlloc&type=gauge&agg=max&step=1000h&from=" + from + "&to=" + to, code: http.StatusOK, count: 1},
+		{name: "default range and avg", query: "?id=HeapAlloc&type=gauge&step=3600000", code: http.StatusOK, count: 1},
+		{name: "unknown metric", query: "?id=Unknown&type=gauge", code: http.StatusOK},
+		{name: "no type", query: "?id=HeapAlloc", code: http.StatusBadRequest},
+		{name: "wrong time", query: "?id=HeapAlloc&type=gauge&from=yesterday", code: http.StatusBadRequest},
+		{name: "wrong step", query: "?id=HeapAlloc&type=gauge&step=-1m", code: http.StatusBadRequest},
+		{name: "rate of gauge", query: "?id=HeapAlloc&type=gauge&agg=rate", code: http.StatusBadRequest},
+		{name: "unknown aggregation", query: "?id=HeapAlloc&type=gauge&agg=median", code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ts.Client().Get(ts.URL + tt.query)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.code, resp.StatusCode)
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			var result query.Result
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			assert.Len(t, result.Points, tt.count)
+		})
+	}
+
+	resp, err := ts.Client().Get(ts.URL + "?id=HeapAlloc&type=gauge&agg=max&step=1000h&from=" + from + "&to=" + to)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var result query.Result
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.Len(t, result.Points, 1)
+	assert.Equal(t, float64(6), result.Points[0].Value)
+	assert.Equal(t, "max", result.Aggregation)
+	assert.Equal(t, float64(3600000), result.Step)
+}
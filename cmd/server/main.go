@@ -2,21 +2,40 @@
 package main
 
 import (
+	"context"
+	"crypto/rsa"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"github.com/aykuli/observer/cmd/server/grpcserver"
 	"github.com/aykuli/observer/cmd/server/routers"
+	"github.com/aykuli/observer/internal/encryptor"
 	"github.com/aykuli/observer/internal/ldflags"
+	"github.com/aykuli/observer/internal/server/agents"
 	"github.com/aykuli/observer/internal/server/config"
+	"github.com/aykuli/observer/internal/server/retention"
 	"github.com/aykuli/observer/internal/server/storage"
 	"github.com/aykuli/observer/internal/server/storage/local"
 	"github.com/aykuli/observer/internal/server/storage/postgres"
+	"github.com/aykuli/observer/internal/server/subnet"
+	"github.com/aykuli/observer/internal/sign"
+	"github.com/aykuli/observer/internal/tlsconfig"
 )
 
+// ShutdownTimeout limits waiting for in-flight requests on server stop.
+const ShutdownTimeout = 10 * time.Second
+
 var (
 	buildVersion string
 	buildDate    string
@@ -52,6 +71,7 @@ func main() {
 	sugar := *serverLogger.Sugar()
 
 	memStorage, err := initStorage(sugar)
+	storageReady := err == nil
 	if err != nil {
 		log.Print(err)
 	}
@@ -62,8 +82,94 @@ func main() {
 		}
 	}()
 
-	if err = http.ListenAndServe(config.Options.Address, routers.MetricsRouter(memStorage, sugar)); err != nil {
-		sugar.Fatalw(err.Error(), "event", "start server")
+	var privateKey *rsa.PrivateKey
+	if config.Options.CryptoKey != "" {
+		if privateKey, err = encryptor.LoadPrivateKey(config.Options.CryptoKey); err != nil {
+			sugar.Fatalw(err.Error(), "event", "load crypto key")
+		}
+	}
+
+	trustedSubnet, err := subnet.New(config.Options.TrustedSubnet, config.Options.TrustedSubnetRemoteAddr)
+	if err != nil {
+		sugar.Fatalw(err.Error(), "event", "parse trusted subnet")
+	}
+
+	agentRegistry := agents.NewRegistry(config.Options.AgentStale)
+	routerOptions := routers.Options{PrivateKey: privateKey, TrustedSubnet: trustedSubnet, Agents: agentRegistry}
+	if config.Options.Key != "" {
+		routerOptions.Verifier = sign.NewVerifier(config.Options.Key, time.Duration(config.Options.SignSkew)*time.Second)
+		routerOptions.Verifier.Legacy = config.Options.SignLegacy
+	}
+	server := &http.Server{Addr: config.Options.Address, Handler: routers.MetricsRouter(memStorage, sugar, routerOptions)}
+	if config.Options.TLSCert != "" {
+		if server.TLSConfig, err = tlsconfig.Server(config.Options.TLSCert, config.Options.TLSKey, config.Options.TLSClientCA); err != nil {
+			sugar.Fatalw(err.Error(), "event", "load TLS settings")
+		}
+	} else if config.Options.TLSClientCA != "" {
+		sugar.Fatalw("client CA requires server TLS certificate", "event", "load TLS settings")
+	}
+
+	go func() {
+		var er error
+		if server.TLSConfig != nil {
+			er = server.ListenAndServeTLS("", "")
+		} else {
+			er = server.ListenAndServe()
+		}
+		if er != nil && !errors.Is(er, http.ErrServerClosed) {
+			sugar.Fatalw(er.Error(), "event", "start server")
+		}
+	}()
+
+	var grpcServer *grpc.Server
+	if config.Options.GRPCAddress != "" {
+		var opts []grpc.ServerOption
+		if server.TLSConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(server.TLSConfig)))
+		}
+		grpcServer = grpcserver.NewServer(memStorage, sugar, grpcserver.Options{
+			Key:           config.Options.Key,
+			Verifier:      routerOptions.Verifier,
+			TrustedSubnet: trustedSubnet,
+			Agents:        agentRegistry,
+		}, opts...)
+
+		listener, er := net.Listen("tcp", config.Options.GRPCAddress)
+		if er != nil {
+			sugar.Fatalw(er.Error(), "event", "start gRPC server")
+		}
+		go func() {
+			if er := grpcServer.Serve(listener); er != nil {
+				sugar.Fatalw(er.Error(), "event", "start gRPC server")
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	policy, err := retention.ParsePolicy(config.Options.Retention)
+	if err != nil {
+		sugar.Fatalw(err.Error(), "event", "parse retention policy")
+	}
+	if store, ok := memStorage.(retention.Store); ok && storageReady {
+		compactor := retention.NewCompactor(store, policy, time.Duration(config.Options.CompactInterval)*time.Second, sugar)
+		go compactor.Run(ctx)
+	}
+
+	<-ctx.Done()
+
+	sugar.Infow("shutting down server", "event", "shutdown")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(shutdownCtx); err != nil {
+		sugar.Errorw(err.Error(), "event", "shutdown server")
+	}
+	if grpcServer != nil {
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+	if err = closeStorage(memStorage); err != nil {
+		sugar.Errorw(err.Error(), "event", "close storage")
 	}
 }
 
@@ -75,3 +181,32 @@ func initStorage(logger zap.SugaredLogger) (storage.Storage, error) {
 
 	return local.NewStorage(config.Options, logger)
 }
+
+// stopGRPC waits for in-flight gRPC calls till context is done and stops server then.
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// closeStorage saves the final metrics snapshot to file or closes database connections.
+func closeStorage(s storage.Storage) error {
+	switch st := s.(type) {
+	case *local.Storage:
+		return st.Close()
+	case *postgres.DBStorage:
+		if st != nil {
+			st.Close()
+		}
+	}
+
+	return nil
+}
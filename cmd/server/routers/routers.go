@@ -2,6 +2,7 @@
 package routers
 
 import (
+	"crypto/rsa"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -10,19 +11,37 @@ import (
 
 	"github.com/aykuli/observer/cmd/server/handlers"
 	"github.com/aykuli/observer/internal/compressor"
+	"github.com/aykuli/observer/internal/encryptor"
+	"github.com/aykuli/observer/internal/server/agents"
 	"github.com/aykuli/observer/internal/server/logger"
 	"github.com/aykuli/observer/internal/server/storage"
+	"github.com/aykuli/observer/internal/server/subnet"
+	"github.com/aykuli/observer/internal/sign"
 )
 
-// MetricsRouter creates and keeps endpoints routing, middlewares them with logger, gzip functionality and handling Content-Type
-func MetricsRouter(storage storage.Storage, sugarLogger zap.SugaredLogger) chi.Router {
+// Options struct keeps optional router settings: private key to decrypt request bodies,
+// trusted subnet of agents allowed to update metrics, verifier of update request signatures
+// and registry of agents sending updates.
+type Options struct {
+	PrivateKey    *rsa.PrivateKey
+	TrustedSubnet *subnet.Checker
+	Verifier      *sign.Verifier
+	Agents        *agents.Registry
+}
+
+// MetricsRouter creates and keeps endpoints routing, middlewares them with logger, gzip functionality and handling Content-Type.
+// If private key is provided, encrypted request bodies are decrypted before unzipping and updates must be encrypted.
+// If trusted subnet is provided, updates from other addresses are forbidden.
+// If verifier is provided, update requests must be signed over their decompressed bodies.
+func MetricsRouter(storage storage.Storage, sugarLogger zap.SugaredLogger, options Options) chi.Router {
 	r := chi.NewRouter()
 	r.Use(logger.WithLogging(sugarLogger))
+	r.Use(encryptor.DecryptMiddleware(options.PrivateKey))
 	r.Use(compressor.GzipMiddleware)
 	r.Use(middleware.AllowContentEncoding("gzip"))
 	r.Use(middleware.AllowContentType("application/json", "text/html", "html/text", "text/plain"))
 
-	v1 := handlers.APIV1{Storage: storage, Logger: sugarLogger}
+	v1 := handlers.APIV1{Storage: storage, Logger: sugarLogger, Agents: options.Agents}
 	docsFs := http.FileServer(http.Dir("docs"))
 
 	r.Route("/", func(r chi.Router) {
@@ -34,17 +53,44 @@ func MetricsRouter(storage storage.Storage, sugarLogger zap.SugaredLogger) chi.R
 			r.Post("/", v1.ReadMetric())
 			r.Get("/{metricType}/{metricName}", v1.GetMetric())
 		})
+		r.Get("/api/v1/query_range", v1.QueryRange())
+		r.Get("/api/v1/agents", v1.ListAgents())
 
 		//Updating endpoints
-		r.Route("/update", func(r chi.Router) {
-			r.Post("/", v1.UpdateFromJSON())
+		r.Group(func(r chi.Router) {
+			r.Use(trustedSubnet(options.TrustedSubnet))
+			r.Use(encryptor.RequireEncryption(options.PrivateKey))
+			r.Use(options.Verifier.Middleware)
 
-			r.Post("/{metricType}/{metricName}/{metricValue}", v1.Update())
+			r.Route("/update", func(r chi.Router) {
+				r.Post("/", v1.UpdateFromJSON())
+
+				r.Post("/{metricType}/{metricName}/{metricValue}", v1.Update())
+			})
+			r.Post("/updates/", v1.BatchUpdate())
 		})
-		r.Post("/updates/", v1.BatchUpdate())
 
 		r.Handle("/swagger/*", http.StripPrefix("/swagger/", docsFs))
 	})
 
 	return r
 }
+
+// trustedSubnet forbids requests of agents outside trusted subnet.
+func trustedSubnet(checker *subnet.Checker) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if checker == nil {
+			return h
+		}
+
+		checkFn := func(w http.ResponseWriter, r *http.Request) {
+			if !checker.Allowed(r.Header.Get(subnet.RealIPHeader), r.RemoteAddr) {
+				http.Error(w, "agent is not in trusted subnet", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(checkFn)
+	}
+}
@@ -1,17 +1,27 @@
 package routers
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
+	"github.com/aykuli/observer/internal/compressor"
+	"github.com/aykuli/observer/internal/encryptor"
+	"github.com/aykuli/observer/internal/server/agents"
 	"github.com/aykuli/observer/internal/server/config"
 	"github.com/aykuli/observer/internal/server/storage/local"
+	"github.com/aykuli/observer/internal/server/subnet"
+	"github.com/aykuli/observer/internal/sign"
 )
 
 func TestLocalStorage(t *testing.T) {
@@ -27,7 +37,7 @@ func TestLocalStorage(t *testing.T) {
 	store, err := local.NewStorage(options, sugar)
 	require.NoError(t, err)
 
-	ts := httptest.NewServer(MetricsRouter(store, sugar))
+	ts := httptest.NewServer(MetricsRouter(store, sugar, Options{}))
 	defer ts.Close()
 
 	t.Run("init storage should be empty", func(t *testing.T) {
@@ -140,7 +150,7 @@ func TestUpdateMetricsRouter(t *testing.T) {
 	}
 	store, err := local.NewStorage(options, sugar)
 	require.NoError(t, err)
-	ts := httptest.NewServer(MetricsRouter(store, sugar))
+	ts := httptest.NewServer(MetricsRouter(store, sugar, Options{}))
 	defer ts.Close()
 
 	type want struct{ code int }
@@ -217,3 +227,259 @@ func TestUpdateMetricsRouter(t *testing.T) {
 		})
 	}
 }
+
+func TestTrustedSubnet(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := *logger.Sugar()
+
+	store, err := local.NewStorage(config.Config{}, sugar)
+	require.NoError(t, err)
+	checker, err := subnet.New("192.168.1.0/24", false)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(MetricsRouter(store, sugar, Options{TrustedSubnet: checker}))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		requestURL string
+		realIP     string
+		code       int
+	}{
+		{name: "update from trusted subnet", method: http.MethodPost, requestURL: "/update/counter/hits/1", realIP: "192.168.1.10", code: http.StatusOK},
+		{name: "update from other subnet", method: http.MethodPost, requestURL: "/update/counter/hits/1", realIP: "10.0.0.1", code: http.StatusForbidden},
+		{name: "update without X-Real-IP", method: http.MethodPost, requestURL: "/update/counter/hits/1", code: http.StatusForbidden},
+		{name: "batch update from other subnet", method: http.MethodPost, requestURL: "/updates/", realIP: "10.0.0.1", code: http.StatusForbidden},
+		{name: "reading is allowed", method: http.MethodGet, requestURL: "/value/counter/hits", realIP: "10.0.0.1", code: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.requestURL, nil)
+			require.NoError(t, err)
+			if tt.realIP != "" {
+				req.Header.Set(subnet.RealIPHeader, tt.realIP)
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.code, resp.StatusCode)
+		})
+	}
+}
+
+func TestEncryptedUpdates(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := *logger.Sugar()
+
+	store, err := local.NewStorage(config.Config{}, sugar)
+	require.NoError(t, err)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(MetricsRouter(store, sugar, Options{PrivateKey: privateKey}))
+	defer ts.Close()
+
+	send := func(method, url string, body []byte, encrypted bool) int {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+url, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if encrypted {
+			req.Header.Set(encryptor.HeaderName, encryptor.HeaderValue)
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	body := []byte(`{"id":"temp","type":"gauge","value":1}`)
+	payload, err := encryptor.Encrypt(&privateKey.PublicKey, body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/update/", payload, true))
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/update/", body, false), "not encrypted update")
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/update/gauge/temp/2", nil, false), "not encrypted URL update")
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/value/gauge/temp", nil, false), "reading isn't encrypted")
+}
+
+func TestSignedUpdates(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := *logger.Sugar()
+
+	key := "secret"
+	store, err := local.NewStorage(config.Config{}, sugar)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(MetricsRouter(store, sugar, Options{Verifier: sign.NewVerifier(key, time.Minute)}))
+	defer ts.Close()
+
+	newRequest := func(path string, body []byte) *http.Request {
+		compressed, err := compressor.Compress(body)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(compressed))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		return req
+	}
+	send := func(req *http.Request) int {
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Body formatting differs from json.Marshal output, signature is checked over bytes as they are sent.
+	body := []byte(`{"type":"gauge","id":"temp","value":1.50}`)
+	req := newRequest("/update/", body)
+	require.NoError(t, sign.SetRequestHeaders(req.Header, key, http.MethodPost, "/update/", body))
+	assert.Equal(t, http.StatusOK, send(req))
+
+	replayed := newRequest("/update/", body)
+	replayed.Header = req.Header.Clone()
+	assert.Equal(t, http.StatusUnauthorized, send(replayed))
+
+	tampered := newRequest("/update/", []byte(`{"type":"gauge","id":"temp","value":100}`))
+	require.NoError(t, sign.SetRequestHeaders(tampered.Header, key, http.MethodPost, "/update/", body))
+	assert.Equal(t, http.StatusUnauthorized, send(tampered))
+
+	pathReq, err := http.NewRequest(http.MethodPost, ts.URL+"/update/counter/hits/5", nil)
+	require.NoError(t, err)
+	require.NoError(t, sign.SetRequestHeaders(pathReq.Header, key, http.MethodPost, "/update/counter/hits/5", nil))
+	assert.Equal(t, http.StatusOK, send(pathReq))
+
+	unsigned, err := http.NewRequest(http.MethodPost, ts.URL+"/update/counter/hits/5", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, send(unsigned))
+
+	resp, err := ts.Client().Get(ts.URL + "/value/counter/hits")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "reading isn't signed")
+}
+
+func TestMetricLabels(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := *logger.Sugar()
+
+	store, err := local.NewStorage(config.Config{}, sugar)
+	require.NoError(t, err)
+	ts := httptest.NewServer(MetricsRouter(store, sugar, Options{}))
+	defer ts.Close()
+
+	send := func(method, url, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+url, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(respBody)
+	}
+
+	code, _ := send(http.MethodPost, "/updates/", `[
+		{"id":"cpu","type":"gauge","value":1,"labels":{"host":"a","service":"api"}},
+		{"id":"cpu","type":"gauge","value":2,"labels":{"host":"b","service":"api"}}
+	]`)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = send(http.MethodPost, "/update/gauge/cpu/3", "")
+	require.Equal(t, http.StatusOK, code)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		code   int
+		value  string
+	}{
+		{name: "labels in query", method: http.MethodGet, url: "/value/gauge/cpu?labels=service=api,host=a", code: http.StatusOK, value: "1"},
+		{name: "other labels", method: http.MethodGet, url: "/value/gauge/cpu?labels=host=b,service=api", code: http.StatusOK, value: "2"},
+		{name: "no labels", method: http.MethodGet, url: "/value/gauge/cpu", code: http.StatusOK, value: "3"},
+		{name: "labels subset", method: http.MethodGet, url: "/value/gauge/cpu?labels=host=a", code: http.StatusNotFound},
+		{name: "wrong labels in query", method: http.MethodGet, url: "/value/gauge/cpu?labels=host", code: http.StatusBadRequest},
+		{name: "labels in JSON", method: http.MethodPost, url: "/value/", body: `{"id":"cpu","type":"gauge","labels":{"host":"b","service":"api"}}`, code: http.StatusOK, value: `"labels":{"host":"b","service":"api"}`},
+		{name: "wrong label name", method: http.MethodPost, url: "/update/", body: `{"id":"cpu","type":"gauge","value":1,"labels":{"1host":"a"}}`, code: http.StatusBadRequest},
+		{name: "empty label value", method: http.MethodPost, url: "/updates/", body: `[{"id":"cpu","type":"gauge","value":1,"labels":{"host":""}}]`, code: http.StatusBadRequest},
+		{name: "series name as metric name", method: http.MethodPost, url: "/update/gauge/cpu%7Bhost=a%7D/5", code: http.StatusBadRequest},
+		{name: "series name as metric name in JSON", method: http.MethodPost, url: "/updates/", body: `[{"id":"cpu{host=b,service=api}","type":"gauge","value":5}]`, code: http.StatusBadRequest},
+		{name: "series name is read with labels only", method: http.MethodGet, url: "/value/gauge/cpu%7Bhost=a,service=api%7D", code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := send(tt.method, tt.url, tt.body)
+			assert.Equal(t, tt.code, code)
+			assert.Contains(t, body, tt.value)
+		})
+	}
+}
+
+func TestAgentsRegistry(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := *logger.Sugar()
+
+	store, err := local.NewStorage(config.Config{}, sugar)
+	require.NoError(t, err)
+	ts := httptest.NewServer(MetricsRouter(store, sugar, Options{Agents: agents.NewRegistry(3)}))
+	defer ts.Close()
+
+	send := func(url, body string, header map[string]string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+url, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	web1 := map[string]string{agents.IDHeader: "web-1", agents.VersionHeader: "v1.2", agents.ReportIntervalHeader: "5"}
+	send("/updates/", `[{"id":"cpu","type":"gauge","value":1},{"id":"hits","type":"counter","delta":1}]`, web1)
+	send("/update/", `{"id":"mem","type":"gauge","value":1}`, web1)
+	send("/update/counter/hits/1", "", map[string]string{agents.IDHeader: "web-2", "X-Real-IP": "192.168.1.10"})
+	send("/update/counter/hits/1", "", nil)
+
+	resp, err := ts.Client().Get(ts.URL + "/api/v1/agents")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var list []agents.Agent
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list, 2)
+
+	assert.Equal(t, "web-1", list[0].ID)
+	assert.Equal(t, "v1.2", list[0].Version)
+	assert.Equal(t, "127.0.0.1", list[0].IP)
+	assert.Equal(t, 5, list[0].ReportInterval)
+	assert.Equal(t, 3, list[0].Metrics)
+	assert.False(t, list[0].Stale)
+
+	assert.Equal(t, "web-2", list[1].ID)
+	assert.Equal(t, "192.168.1.10", list[1].IP)
+	assert.Equal(t, 10, list[1].ReportInterval, "default report interval")
+	assert.Equal(t, 1, list[1].Metrics)
+}
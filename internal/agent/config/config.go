@@ -1,7 +1,11 @@
 // Package config provides parsing configuration provided on application start.
 package config
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"time"
+)
 
 // Config struct keeps tags provided from console on application start.
 type Config struct {
@@ -10,6 +14,106 @@ type Config struct {
 	PollInterval   int    `env:"POLL_INTERVAL"`
 	Key            string `env:"KEY"`
 	RateLimit      int    `env:"RATE_LIMIT"`
+	ConfigFile     string `env:"CONFIG"`
+	PushAddress    string `env:"PUSH_ADDRESS"`
+	QueueDir       string `env:"QUEUE_DIR"`
+	QueueMaxBytes  int64  `env:"QUEUE_MAX_BYTES"`
+	QueueMaxAge    int    `env:"QUEUE_MAX_AGE"`
+	CryptoKey      string `env:"CRYPTO_KEY"`
+	TLSCA          string `env:"TLS_CA"`
+	TLSCert        string `env:"TLS_CERT"`
+	TLSKey         string `env:"TLS_KEY"`
+	GRPCAddress    string `env:"GRPC_ADDRESS"`
+	Labels         string `env:"LABELS"`
+	HostLabel      bool   `env:"HOST_LABEL"`
+	AgentID        string `env:"AGENT_ID"`
+
+	Collectors map[string]CollectorConfig
+	Disk       DiskConfig
+	Processes  []ProcessConfig
+	CgroupRoot string
+	CPU        CPUConfig
+	Exec       []ExecConfig
+	Logs       []LogConfig
+	LogState   string
+
+	RuntimeMetrics RuntimeMetricsConfig
+	StatsD         StatsDConfig
+}
+
+// StatsDConfig struct keeps addresses of StatsD listener: UDP host:port and unix datagram socket path.
+type StatsDConfig struct {
+	UDP  string `json:"udp,omitempty"`
+	Unix string `json:"unix,omitempty"`
+}
+
+// RuntimeMetricsConfig struct keeps runtime_metrics collector settings.
+// Compat mode keeps reporting legacy runtime.MemStats names.
+type RuntimeMetricsConfig struct {
+	Compat bool `json:"compat"`
+}
+
+// LogConfig struct describes log file followed by logtail collector.
+type LogConfig struct {
+	Path     string             `json:"path"`
+	Patterns []LogPatternConfig `json:"patterns"`
+}
+
+// LogPatternConfig struct describes regular expression turned into counter and optional gauge metrics.
+type LogPatternConfig struct {
+	Counter string `json:"counter"`
+	Regex   string `json:"regex"`
+	Gauge   string `json:"gauge,omitempty"`
+	Group   int    `json:"group,omitempty"`
+}
+
+// ExecConfig struct describes external command run by exec collector.
+// Timeout is provided in seconds.
+type ExecConfig struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	Timeout int      `json:"timeout,omitempty"`
+}
+
+// CPU collector modes
+const (
+	CPUModePerCore   = "per_core"
+	CPUModeAggregate = "aggregate"
+	CPUModeBoth      = "both"
+)
+
+// CPUConfig struct keeps CPU collector mode: per_core, aggregate or both.
+type CPUConfig struct {
+	Mode string `json:"mode"`
+}
+
+func (c CPUConfig) validate() error {
+	switch c.Mode {
+	case CPUModePerCore, CPUModeAggregate, CPUModeBoth:
+		return nil
+	default:
+		return fmt.Errorf("unknown cpu mode %q, it should be %s, %s or %s", c.Mode, CPUModePerCore, CPUModeAggregate, CPUModeBoth)
+	}
+}
+
+// ProcessConfig struct describes group of processes tracked by process collector.
+type ProcessConfig struct {
+	Name    string `json:"name"`
+	Process string `json:"process,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+	Pidfile string `json:"pidfile,omitempty"`
+}
+
+// DiskConfig struct keeps filesystem types to be reported or skipped by disk collector.
+type DiskConfig struct {
+	IncludeFsTypes []string `json:"include_fs_types,omitempty"`
+	ExcludeFsTypes []string `json:"exclude_fs_types,omitempty"`
+}
+
+// CollectorConfig struct keeps collector settings provided in config file.
+type CollectorConfig struct {
+	Enabled      *bool `json:"enabled,omitempty"`
+	PollInterval int   `json:"poll_interval,omitempty"`
 }
 
 // Configuration default constants
@@ -18,12 +122,27 @@ const (
 	pollIntervalDefault   = 10
 	hostDefault           = "localhost"
 	portDefault           = "8080"
+	logStateDefault       = "/tmp/observer-agent-logtail.json"
+	queueMaxBytesDefault  = 64 << 20
+	queueMaxAgeDefault    = 24 * 60 * 60
 )
 
 var Options = Config{
 	Address:        hostDefault + ":" + portDefault,
 	ReportInterval: reportIntervalDefault,
 	PollInterval:   pollIntervalDefault,
+	QueueMaxBytes:  queueMaxBytesDefault,
+	QueueMaxAge:    queueMaxAgeDefault,
+	CPU:            CPUConfig{Mode: CPUModeBoth},
+	LogState:       logStateDefault,
+	RuntimeMetrics: RuntimeMetricsConfig{Compat: true},
+	Collectors: map[string]CollectorConfig{
+		// runtime_metrics is alternative to runtime collector, it is enabled explicitly.
+		"runtime_metrics": {Enabled: new(bool)},
+	},
+	Disk: DiskConfig{
+		ExcludeFsTypes: []string{"tmpfs", "devtmpfs", "overlay", "squashfs"},
+	},
 }
 
 // ServerAddr struct provides server host and port.
@@ -32,7 +151,34 @@ type ServerAddr struct {
 	Port string
 }
 
+// CollectorSettings returns whether collector is enabled and how often it has to be polled.
+// Collectors missing in config file are enabled and polled every PollInterval seconds.
+// Runtime collector is disabled if runtime_metrics collector is enabled in compat mode, because the latter
+// reports the same metrics including PollCount, which would be counted twice.
+func (c Config) CollectorSettings(name string) (bool, time.Duration) {
+	enabled := true
+	interval := c.PollInterval
+
+	if cc, ok := c.Collectors[name]; ok {
+		if cc.Enabled != nil {
+			enabled = *cc.Enabled
+		}
+		if cc.PollInterval > 0 {
+			interval = cc.PollInterval
+		}
+	}
+
+	if name == "runtime" && c.RuntimeMetrics.Compat {
+		if compatEnabled, _ := c.CollectorSettings("runtime_metrics"); compatEnabled {
+			enabled = false
+		}
+	}
+
+	return enabled, time.Duration(interval) * time.Second
+}
+
 func init() {
 	parseFlags(os.Args[1:])
 	parseEnvVars()
+	parseConfigFile()
 }
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// fileConfig struct describes JSON config file. It keeps settings
+// which are too complex to be provided with flags or environment variables.
+type fileConfig struct {
+	Collectors map[string]CollectorConfig `json:"collectors"`
+	Disk       DiskConfig                 `json:"disk"`
+	Processes  []ProcessConfig            `json:"processes"`
+	CgroupRoot string                     `json:"cgroup_root"`
+	CPU        CPUConfig                  `json:"cpu"`
+	Exec       []ExecConfig               `json:"exec"`
+	Logs       []LogConfig                `json:"logs"`
+	LogState   string                     `json:"log_state"`
+
+	RuntimeMetrics RuntimeMetricsConfig `json:"runtime_metrics"`
+	StatsD         StatsDConfig         `json:"statsd"`
+}
+
+func parseConfigFile() {
+	if Options.ConfigFile == "" {
+		return
+	}
+
+	if err := loadConfigFile(Options.ConfigFile); err != nil {
+		log.Print(err)
+	}
+}
+
+// loadConfigFile applies config file settings. Unknown CPU mode is reported as error and previous mode is kept,
+// other settings are applied anyway.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	fc := fileConfig{
+		Collectors: Options.Collectors,
+		Disk:       Options.Disk,
+		Processes:  Options.Processes,
+		CgroupRoot: Options.CgroupRoot,
+		CPU:        Options.CPU,
+		Exec:       Options.Exec,
+		Logs:       Options.Logs,
+		LogState:   Options.LogState,
+
+		RuntimeMetrics: Options.RuntimeMetrics,
+		StatsD:         Options.StatsD,
+	}
+	if err = json.Unmarshal(data, &fc); err != nil {
+		return err
+	}
+	if err = fc.CPU.validate(); err != nil {
+		fc.CPU = Options.CPU
+	}
+
+	Options.Collectors = fc.Collectors
+	Options.Disk = fc.Disk
+	Options.Processes = fc.Processes
+	Options.CgroupRoot = fc.CgroupRoot
+	Options.CPU = fc.CPU
+	Options.Exec = fc.Exec
+	Options.Logs = fc.Logs
+	Options.LogState = fc.LogState
+	Options.RuntimeMetrics = fc.RuntimeMetrics
+	Options.StatsD = fc.StatsD
+	return err
+}
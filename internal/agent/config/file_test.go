@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectorSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	content := `{"collectors": {"runtime": {"poll_interval": 7}, "system": {"enabled": false}}}`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	require.NoError(t, loadConfigFile(path))
+	Options.PollInterval = 3
+
+	enabled, interval := Options.CollectorSettings("runtime")
+	assert.True(t, enabled)
+	assert.Equal(t, 7*time.Second, interval)
+
+	enabled, _ = Options.CollectorSettings("system")
+	assert.False(t, enabled)
+
+	enabled, interval = Options.CollectorSettings("unknown")
+	assert.True(t, enabled)
+	assert.Equal(t, 3*time.Second, interval)
+}
+
+func TestRuntimeMetricsDisabledByDefault(t *testing.T) {
+	enabled, _ := Options.CollectorSettings("runtime_metrics")
+	assert.False(t, enabled)
+	assert.True(t, Options.RuntimeMetrics.Compat)
+}
+
+func TestRuntimeCollectorsOwnPollCountAlternately(t *testing.T) {
+	enabled := true
+	c := Config{
+		PollInterval:   2,
+		RuntimeMetrics: RuntimeMetricsConfig{Compat: true},
+		Collectors:     map[string]CollectorConfig{"runtime_metrics": {Enabled: &enabled}},
+	}
+	runtimeEnabled, _ := c.CollectorSettings("runtime")
+	assert.False(t, runtimeEnabled, "runtime_metrics in compat mode reports PollCount")
+
+	c.RuntimeMetrics.Compat = false
+	runtimeEnabled, _ = c.CollectorSettings("runtime")
+	assert.True(t, runtimeEnabled)
+
+	c.RuntimeMetrics.Compat = true
+	c.Collectors = map[string]CollectorConfig{"runtime_metrics": {Enabled: new(bool)}}
+	runtimeEnabled, _ = c.CollectorSettings("runtime")
+	assert.True(t, runtimeEnabled, "runtime_metrics is disabled")
+}
+
+func TestCPUMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"cpu": {"mode": "aggregate"}}`), 0o600))
+	require.NoError(t, loadConfigFile(path))
+	assert.Equal(t, CPUModeAggregate, Options.CPU.Mode)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"cpu": {"mode": "total"}, "log_state": "/tmp/state.json"}`), 0o600))
+	assert.Error(t, loadConfigFile(path))
+	assert.Equal(t, CPUModeAggregate, Options.CPU.Mode, "unknown mode is ignored")
+	assert.Equal(t, "/tmp/state.json", Options.LogState, "other settings are applied")
+}
@@ -7,11 +7,24 @@ import (
 
 func parseFlags(args []string) {
 	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
-	fs.StringVar(&Options.Address, "a", hostDefault+":"+portDefault, "server address to post metric values")
+	fs.StringVar(&Options.Address, "a", hostDefault+":"+portDefault, "server address to post metric values, http:// or https:// scheme might be provided")
 	fs.IntVar(&Options.ReportInterval, "r", 10, "report interval in second to post metric values on server")
 	fs.IntVar(&Options.PollInterval, "p", 2, "metric values refreshing interval in second")
 	fs.StringVar(&Options.Key, "k", "", "secret key to sign request")
 	fs.IntVar(&Options.RateLimit, "l", 0, "limit sequential requests to server")
+	fs.StringVar(&Options.ConfigFile, "c", "", "path to JSON config file with collectors settings")
+	fs.StringVar(&Options.PushAddress, "e", "", "local address to accept metrics pushed by applications, e.g. localhost:8081")
+	fs.StringVar(&Options.QueueDir, "q", "", "directory to keep metrics failed to be sent, e.g. /var/lib/observer-agent/queue, the queue is disabled by default")
+	fs.Int64Var(&Options.QueueMaxBytes, "qb", queueMaxBytesDefault, "max size of unsent metrics queue in bytes, the oldest metrics are dropped")
+	fs.IntVar(&Options.QueueMaxAge, "qa", queueMaxAgeDefault, "max age of unsent metrics in seconds")
+	fs.StringVar(&Options.CryptoKey, "crypto-key", "", "path to PEM file with server RSA public key to encrypt requests")
+	fs.StringVar(&Options.GRPCAddress, "grpc-address", "", "server gRPC address, metrics are sent via gRPC instead of HTTP if it is set")
+	fs.StringVar(&Options.AgentID, "agent-id", "", "agent identifier sent to server, hostname by default")
+	fs.StringVar(&Options.Labels, "labels", "", "static labels attached to every metric, e.g. service=api,env=prod")
+	fs.BoolVar(&Options.HostLabel, "host-label", false, "attach host label with machine hostname to every metric unless it is set in labels")
+	fs.StringVar(&Options.TLSCA, "tls-ca", "", "path to PEM file with CA certificates to verify server, enables HTTPS")
+	fs.StringVar(&Options.TLSCert, "tls-cert", "", "path to PEM file with agent client certificate, enables HTTPS")
+	fs.StringVar(&Options.TLSKey, "tls-key", "", "path to PEM file with agent client private key")
 
 	err := fs.Parse(args)
 	if err != nil {
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package queue
+
+import "os"
+
+// lockDir only creates lock file on systems without flock, the directory isn't protected there.
+func lockDir(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+}
@@ -0,0 +1,376 @@
+// Package queue provides durable on-disk FIFO queue of metric batches
+// the agent failed to send. Batches are appended to segment files, segments
+// are deleted when they are consumed or queue exceeds its size or age limits.
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aykuli/observer/internal/models"
+)
+
+const (
+	segmentExt    = ".seg"
+	cursorFile    = "cursor.json"
+	lockFile      = "lock"
+	segmentsCount = 8 // max bytes are split between this number of segments
+)
+
+// ErrLocked is returned by Open if the queue directory is used by another agent.
+var ErrLocked = errors.New("queue directory is locked by another agent")
+
+// errCorrupted is returned by read for batch which can't be decoded.
+var errCorrupted = errors.New("corrupted batch")
+
+// Batch struct keeps metrics with the moment they were collected.
+type Batch struct {
+	Timestamp time.Time       `json:"ts"`
+	Metrics   []models.Metric `json:"metrics"`
+}
+
+// cursor points to the first unconsumed batch.
+type cursor struct {
+	Segment int64 `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// Queue struct keeps segments directory, limits and read position.
+type Queue struct {
+	dir          string
+	maxBytes     int64
+	maxAge       time.Duration
+	segmentBytes int64
+
+	lock     *os.File
+	mutex    sync.Mutex
+	segments []int64
+	cursor   cursor
+	now      func() time.Time
+}
+
+// Open opens queue in dir, creating it if needed, and restores unsent batches.
+// Zero maxBytes or maxAge disables the limit. The directory is locked till Close,
+// ErrLocked is returned if another agent keeps its queue there.
+func Open(dir string, maxBytes int64, maxAge time.Duration) (q *Queue, err error) {
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(filepath.Join(dir, lockFile))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			lock.Close()
+		}
+	}()
+
+	q = &Queue{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		maxAge:       maxAge,
+		segmentBytes: maxBytes / segmentsCount,
+		lock:         lock,
+		now:          time.Now,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		id, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), segmentExt), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		q.segments = append(q.segments, id)
+	}
+	slices.Sort(q.segments)
+
+	if err = q.repairTail(); err != nil {
+		return nil, err
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, cursorFile)); err == nil {
+		if err = json.Unmarshal(data, &q.cursor); err != nil {
+			q.cursor = cursor{}
+		}
+	}
+	if len(q.segments) > 0 && !slices.Contains(q.segments, q.cursor.Segment) {
+		q.cursor = cursor{Segment: q.segments[0]}
+	}
+	// Segments before the cursor are consumed, but weren't deleted because agent was stopped.
+	for len(q.segments) > 0 && q.segments[0] < q.cursor.Segment {
+		if err = q.dropHead(); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// Close releases the queue directory lock.
+func (q *Queue) Close() error {
+	return q.lock.Close()
+}
+
+// Push appends batch to the tail of the queue and drops the oldest segments above the limits.
+func (q *Queue) Push(batch Batch) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, 1)
+		q.cursor = cursor{Segment: 1}
+	} else if q.segmentBytes > 0 {
+		size, err := q.segmentSize(q.tail())
+		if err != nil {
+			return err
+		}
+		if size > 0 && size+int64(len(data)) > q.segmentBytes {
+			q.segments = append(q.segments, q.tail()+1)
+		}
+	}
+
+	f, err := os.OpenFile(q.segmentPath(q.tail()), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return q.enforceLimits()
+}
+
+// Peek returns the oldest unconsumed batch, false is returned if queue is empty.
+// Batches older than max age are skipped, so are corrupted ones, otherwise the queue would never drain.
+func (q *Queue) Peek() (Batch, bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.segments) > 0 {
+		batch, size, err := q.read()
+		if errors.Is(err, io.EOF) {
+			if q.cursor.Segment == q.tail() {
+				return Batch{}, false, nil
+			}
+			if err = q.dropHead(); err != nil {
+				return Batch{}, false, err
+			}
+			continue
+		}
+		if errors.Is(err, errCorrupted) {
+			log.Printf("Err reading send queue, batch is dropped: %+v", err)
+			if err = q.advance(size); err != nil {
+				return Batch{}, false, err
+			}
+			continue
+		}
+		if err != nil {
+			return Batch{}, false, err
+		}
+
+		if q.maxAge > 0 && q.now().Sub(batch.Timestamp) > q.maxAge {
+			if err = q.advance(size); err != nil {
+				return Batch{}, false, err
+			}
+			continue
+		}
+
+		return batch, true, nil
+	}
+
+	return Batch{}, false, nil
+}
+
+// Ack marks the batch returned by Peek as sent.
+func (q *Queue) Ack() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	_, size, err := q.read()
+	if err != nil && !errors.Is(err, errCorrupted) {
+		return err
+	}
+	return q.advance(size)
+}
+
+// Empty returns true if there are no batches to send.
+func (q *Queue) Empty() bool {
+	_, ok, err := q.Peek()
+	return err == nil && !ok
+}
+
+// read decodes batch at the cursor and returns its size in bytes. Size is returned for corrupted batch too,
+// so it can be skipped.
+func (q *Queue) read() (Batch, int64, error) {
+	f, err := os.Open(q.segmentPath(q.cursor.Segment))
+	if err != nil {
+		return Batch{}, 0, err
+	}
+	defer f.Close()
+
+	if _, err = f.Seek(q.cursor.Offset, io.SeekStart); err != nil {
+		return Batch{}, 0, err
+	}
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return Batch{}, 0, io.EOF
+	}
+
+	var batch Batch
+	if err = json.Unmarshal(line, &batch); err != nil {
+		return Batch{}, int64(len(line)), fmt.Errorf("%w in segment %d: %v", errCorrupted, q.cursor.Segment, err)
+	}
+	return batch, int64(len(line)), nil
+}
+
+func (q *Queue) advance(size int64) error {
+	q.cursor.Offset += size
+
+	// The whole queue is consumed, the last segment isn't needed anymore.
+	if q.cursor.Segment == q.tail() {
+		tailSize, err := q.segmentSize(q.tail())
+		if err != nil {
+			return err
+		}
+		if q.cursor.Offset >= tailSize {
+			return q.dropHead()
+		}
+	}
+
+	return q.saveCursor()
+}
+
+// dropHead deletes the oldest segment and moves cursor to the next one.
+func (q *Queue) dropHead() error {
+	if err := os.Remove(q.segmentPath(q.segments[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	q.segments = q.segments[1:]
+
+	if len(q.segments) == 0 {
+		q.cursor = cursor{}
+	} else if q.cursor.Segment < q.segments[0] {
+		q.cursor = cursor{Segment: q.segments[0]}
+	}
+	return q.saveCursor()
+}
+
+// enforceLimits drops the oldest segments while queue is bigger than max bytes
+// or segments are older than max age. The tail segment is kept.
+func (q *Queue) enforceLimits() error {
+	for len(q.segments) > 1 {
+		total, err := q.totalSize()
+		if err != nil {
+			return err
+		}
+
+		fi, err := os.Stat(q.segmentPath(q.segments[0]))
+		if err != nil {
+			return err
+		}
+		tooBig := q.maxBytes > 0 && total > q.maxBytes
+		tooOld := q.maxAge > 0 && q.now().Sub(fi.ModTime()) > q.maxAge
+		if !tooBig && !tooOld {
+			return nil
+		}
+
+		if err = q.dropHead(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// repairTail cuts incomplete batch written when agent was killed during writing.
+func (q *Queue) repairTail() error {
+	if len(q.segments) == 0 {
+		return nil
+	}
+
+	path := q.segmentPath(q.tail())
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	complete := strings.LastIndexByte(string(data), '\n') + 1
+	if complete == len(data) {
+		return nil
+	}
+	return os.Truncate(path, int64(complete))
+}
+
+func (q *Queue) saveCursor() error {
+	data, err := json.Marshal(q.cursor)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(q.dir, cursorFile+".tmp")
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(q.dir, cursorFile))
+}
+
+func (q *Queue) totalSize() (int64, error) {
+	var total int64
+	for _, id := range q.segments {
+		size, err := q.segmentSize(id)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+func (q *Queue) segmentSize(id int64) (int64, error) {
+	fi, err := os.Stat(q.segmentPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (q *Queue) tail() int64 {
+	return q.segments[len(q.segments)-1]
+}
+
+func (q *Queue) segmentPath(id int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d%s", id, segmentExt))
+}
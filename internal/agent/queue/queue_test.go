@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aykuli/observer/internal/models"
+)
+
+func batchOf(id string, ts time.Time) Batch {
+	delta := int64(1)
+	return Batch{Timestamp: ts, Metrics: []models.Metric{{ID: id, MType: "counter", Delta: &delta}}}
+}
+
+func drain(t *testing.T, q *Queue) []string {
+	t.Helper()
+	var ids []string
+	for {
+		batch, ok, err := q.Peek()
+		require.NoError(t, err)
+		if !ok {
+			return ids
+		}
+		ids = append(ids, batch.Metrics[0].ID)
+		require.NoError(t, q.Ack())
+	}
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("replays in order after restart", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir, 0, 0)
+		require.NoError(t, err)
+		assert.True(t, q.Empty())
+
+		now := time.Now()
+		for _, id := range []string{"a", "b", "c"} {
+			require.NoError(t, q.Push(batchOf(id, now)))
+		}
+
+		batch, ok, err := q.Peek()
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "a", batch.Metrics[0].ID)
+		require.NoError(t, q.Ack())
+		require.NoError(t, q.Close())
+
+		reopened, err := Open(dir, 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b", "c"}, drain(t, reopened))
+		assert.True(t, reopened.Empty())
+
+		files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+		require.NoError(t, err)
+		assert.Empty(t, files, "consumed segments are deleted")
+	})
+
+	t.Run("drops oldest segments above max bytes", func(t *testing.T) {
+		q, err := Open(t.TempDir(), 800, 0)
+		require.NoError(t, err)
+
+		now := time.Now()
+		for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+			require.NoError(t, q.Push(batchOf(id, now)))
+		}
+
+		total, err := q.totalSize()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, total, int64(800))
+
+		ids := drain(t, q)
+		assert.Less(t, len(ids), 12)
+		assert.Equal(t, "l", ids[len(ids)-1], "the newest batch is kept")
+	})
+
+	t.Run("skips batches older than max age", func(t *testing.T) {
+		q, err := Open(t.TempDir(), 0, time.Hour)
+		require.NoError(t, err)
+
+		now := time.Now()
+		require.NoError(t, q.Push(batchOf("old", now.Add(-2*time.Hour))))
+		require.NoError(t, q.Push(batchOf("fresh", now)))
+
+		assert.Equal(t, []string{"fresh"}, drain(t, q))
+	})
+
+	t.Run("cuts batch written partially", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir, 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, q.Push(batchOf("a", time.Now())))
+
+		f, err := os.OpenFile(q.segmentPath(q.tail()), os.O_WRONLY|os.O_APPEND, 0o600)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"ts":"2024-01-01T00:00:00Z","metr`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.NoError(t, q.Close())
+
+		reopened, err := Open(dir, 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, reopened.Push(batchOf("b", time.Now())))
+		assert.Equal(t, []string{"a", "b"}, drain(t, reopened))
+	})
+
+	t.Run("skips corrupted batches", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir, 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, q.Push(batchOf("a", time.Now())))
+
+		f, err := os.OpenFile(q.segmentPath(q.tail()), os.O_WRONLY|os.O_APPEND, 0o600)
+		require.NoError(t, err)
+		_, err = f.WriteString("\x00garbage\n")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.NoError(t, q.Push(batchOf("b", time.Now())))
+
+		assert.Equal(t, []string{"a", "b"}, drain(t, q))
+		assert.True(t, q.Empty())
+	})
+
+	t.Run("directory is locked by one queue", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := Open(dir, 0, 0)
+		require.NoError(t, err)
+
+		_, err = Open(dir, 0, 0)
+		assert.ErrorIs(t, err, ErrLocked)
+
+		require.NoError(t, q.Close())
+		reopened, err := Open(dir, 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, reopened.Close())
+	})
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CgroupRootDefault is the mountpoint of cgroup v2 unified hierarchy.
+const CgroupRootDefault = "/sys/fs/cgroup"
+
+// ErrNoCgroup is returned if agent isn't running inside container with cgroup v2.
+var ErrNoCgroup = errors.New("cgroup v2 of container is not found")
+
+// containerMarkers are files container runtimes put into container root filesystem.
+var containerMarkers = []string{".dockerenv", "run/.containerenv"}
+
+// InContainer reports whether agent runs in container. Docker and Podman leave marker files in rootFS,
+// systemd-nspawn, Podman and LXC set "container" environment variable and Kubernetes sets KUBERNETES_SERVICE_HOST.
+func InContainer(rootFS string) bool {
+	for _, marker := range containerMarkers {
+		if _, err := os.Stat(filepath.Join(rootFS, marker)); err == nil {
+			return true
+		}
+	}
+	return os.Getenv("container") != "" || os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// DetectCgroupDir returns directory of cgroup v2 of container the agent process runs in.
+// selfCgroupFile is the path to /proc/self/cgroup. In containers with cgroup namespace
+// the process path is "/", so root itself is the container cgroup; host root cgroup has no memory.current.
+// Nested path like "/system.slice/docker-abc.scope" is used only if containerized is true, otherwise
+// it is a systemd slice or session of a bare host and its usage isn't reported as container one.
+func DetectCgroupDir(root, selfCgroupFile string, containerized bool) (string, error) {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return "", ErrNoCgroup
+	}
+
+	data, err := os.ReadFile(selfCgroupFile)
+	if err != nil {
+		return "", err
+	}
+
+	dir := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		path, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		if path == "/" || containerized {
+			dir = filepath.Join(root, path)
+		}
+		break
+	}
+	if dir == "" {
+		return "", ErrNoCgroup
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "memory.current")); err != nil {
+		return "", ErrNoCgroup
+	}
+	return dir, nil
+}
+
+// CgroupCollector gathers container-scoped memory, CPU, I/O and pids usage from cgroup v2 files.
+type CgroupCollector struct {
+	dir      string
+	counters deltaTracker
+	lastCPU  cpuSample
+
+	now func() time.Time
+}
+
+// NewCgroupCollector returns CgroupCollector reading files from provided cgroup directory.
+func NewCgroupCollector(dir string) *CgroupCollector {
+	return &CgroupCollector{
+		dir:      dir,
+		counters: deltaTracker{},
+		now:      time.Now,
+	}
+}
+
+// Name returns collector name used in configuration.
+func (c *CgroupCollector) Name() string {
+	return "cgroup"
+}
+
+// Collect saves cgroup.memory.*, cgroup.cpu.*, cgroup.io.* and cgroup.pids.* metrics.
+// Limits set to "max" are not reported.
+func (c *CgroupCollector) Collect(m *MemStorage) error {
+	memCurrent, err := c.readValue("memory.current")
+	if err != nil {
+		return err
+	}
+	m.SetGauge("cgroup.memory.current", float64(memCurrent))
+
+	if memMax, err := c.readValue("memory.max"); err == nil {
+		m.SetGauge("cgroup.memory.max", float64(memMax))
+	}
+
+	if pids, err := c.readValue("pids.current"); err == nil {
+		m.SetGauge("cgroup.pids.current", float64(pids))
+	}
+	if pidsMax, err := c.readValue("pids.max"); err == nil {
+		m.SetGauge("cgroup.pids.max", float64(pidsMax))
+	}
+
+	if err = c.collectCPU(m); err != nil {
+		return err
+	}
+
+	return c.collectIO(m)
+}
+
+func (c *CgroupCollector) collectCPU(m *MemStorage) error {
+	stat, err := c.readKeyValues("cpu.stat")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range []string{"usage_usec", "user_usec", "system_usec", "nr_periods", "nr_throttled", "throttled_usec"} {
+		value, ok := stat[key]
+		if !ok {
+			continue
+		}
+		name := "cgroup.cpu." + key
+		if delta, ok := c.counters.Delta(name, value); ok {
+			m.AddCounter(name, delta)
+		}
+	}
+
+	now := c.now()
+	if usage, ok := stat["usage_usec"]; ok {
+		usageSeconds := float64(usage) / 1e6
+		if !c.lastCPU.at.IsZero() {
+			if elapsed := now.Sub(c.lastCPU.at).Seconds(); elapsed > 0 {
+				m.SetGauge("cgroup.cpu.percent", (usageSeconds-c.lastCPU.cpuTime)/elapsed*100)
+			}
+		}
+		c.lastCPU = cpuSample{cpuTime: usageSeconds, at: now}
+	}
+
+	// cpu.max keeps "$QUOTA $PERIOD", quota is "max" if CPU is not limited.
+	if data, err := os.ReadFile(filepath.Join(c.dir, "cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 {
+			quota, errQuota := strconv.ParseFloat(fields[0], 64)
+			period, errPeriod := strconv.ParseFloat(fields[1], 64)
+			if errQuota == nil && errPeriod == nil && period > 0 {
+				m.SetGauge("cgroup.cpu.limit_cores", quota/period)
+			}
+		}
+	}
+
+	return nil
+}
+
+// collectIO sums io.stat counters over all devices.
+// Every io.stat line looks like "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0".
+func (c *CgroupCollector) collectIO(m *MemStorage) error {
+	file, err := os.Open(filepath.Join(c.dir, "io.stat"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	totals := map[string]uint64{"rbytes": 0, "wbytes": 0, "rios": 0, "wios": 0}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if _, known := totals[key]; !ok || !known {
+				continue
+			}
+			if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+				totals[key] += v
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	for key, value := range totals {
+		name := "cgroup.io." + key
+		if delta, ok := c.counters.Delta(name, value); ok {
+			m.AddCounter(name, delta)
+		}
+	}
+
+	return nil
+}
+
+// readValue reads file with single number, "max" value is returned as error.
+func (c *CgroupCollector) readValue(file string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, file))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readKeyValues reads flat keyed file like cpu.stat with "key value" lines.
+func (c *CgroupCollector) readKeyValues(file string) (map[string]uint64, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, file))
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+}
+
+func TestDetectCgroupDir(t *testing.T) {
+	root := t.TempDir()
+	selfCgroup := filepath.Join(t.TempDir(), "cgroup")
+
+	t.Run("no cgroup v2", func(t *testing.T) {
+		_, err := DetectCgroupDir(root, selfCgroup, true)
+		assert.ErrorIs(t, err, ErrNoCgroup)
+	})
+
+	writeFiles(t, root, map[string]string{"cgroup.controllers": "cpu io memory pids", "memory.current": "1"})
+
+	t.Run("cgroup namespace", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(selfCgroup, []byte("0::/\n"), 0o600))
+		dir, err := DetectCgroupDir(root, selfCgroup, false)
+		require.NoError(t, err)
+		assert.Equal(t, root, dir)
+	})
+
+	t.Run("systemd slice of bare host", func(t *testing.T) {
+		slice := filepath.Join(root, "user.slice", "user-1000.slice", "session-2.scope")
+		writeFiles(t, slice, map[string]string{"memory.current": "1"})
+		require.NoError(t, os.WriteFile(selfCgroup, []byte("0::/user.slice/user-1000.slice/session-2.scope\n"), 0o600))
+
+		_, err := DetectCgroupDir(root, selfCgroup, false)
+		assert.ErrorIs(t, err, ErrNoCgroup)
+	})
+
+	t.Run("nested cgroup", func(t *testing.T) {
+		nested := filepath.Join(root, "system.slice", "docker-abc.scope")
+		writeFiles(t, nested, map[string]string{"memory.current": "1"})
+		require.NoError(t, os.WriteFile(selfCgroup, []byte("0::/system.slice/docker-abc.scope\n"), 0o600))
+
+		dir, err := DetectCgroupDir(root, selfCgroup, true)
+		require.NoError(t, err)
+		assert.Equal(t, nested, dir)
+	})
+}
+
+func TestInContainer(t *testing.T) {
+	t.Setenv("container", "")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	rootFS := t.TempDir()
+	assert.False(t, InContainer(rootFS))
+
+	t.Run("env marker", func(t *testing.T) {
+		t.Setenv("container", "podman")
+		assert.True(t, InContainer(rootFS))
+	})
+
+	t.Run("docker marker", func(t *testing.T) {
+		writeFiles(t, rootFS, map[string]string{".dockerenv": ""})
+		assert.True(t, InContainer(rootFS))
+	})
+}
+
+func TestCgroupCollector(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"memory.current": "104857600\n",
+		"memory.max":     "max\n",
+		"pids.current":   "12\n",
+		"pids.max":       "100\n",
+		"cpu.max":        "50000 100000\n",
+		"cpu.stat":       "usage_usec 1000000\nuser_usec 600000\nsystem_usec 400000\nnr_periods 10\nnr_throttled 1\nthrottled_usec 500\n",
+		"io.stat":        "8:0 rbytes=100 wbytes=200 rios=1 wios=2 dbytes=0 dios=0\n8:16 rbytes=50 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n",
+	})
+
+	now := time.Now()
+	c := NewCgroupCollector(dir)
+	c.now = func() time.Time { return now }
+
+	memStorage := NewMemStorage()
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics := metricsByID(&memStorage)
+	assert.Equal(t, float64(104857600), *metrics["cgroup.memory.current"].Value)
+	assert.NotContains(t, metrics, "cgroup.memory.max")
+	assert.Equal(t, float64(12), *metrics["cgroup.pids.current"].Value)
+	assert.Equal(t, float64(100), *metrics["cgroup.pids.max"].Value)
+	assert.Equal(t, 0.5, *metrics["cgroup.cpu.limit_cores"].Value)
+
+	writeFiles(t, dir, map[string]string{
+		"cpu.stat": "usage_usec 2000000\nuser_usec 1200000\nsystem_usec 800000\nnr_periods 20\nnr_throttled 4\nthrottled_usec 900\n",
+		"io.stat":  "8:0 rbytes=300 wbytes=200 rios=3 wios=2 dbytes=0 dios=0\n8:16 rbytes=50 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n",
+	})
+	now = now.Add(4 * time.Second)
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics = metricsByID(&memStorage)
+	assert.InDelta(t, 25, *metrics["cgroup.cpu.percent"].Value, 0.001)
+	assert.Equal(t, int64(3), *metrics["cgroup.cpu.nr_throttled"].Delta)
+	assert.Equal(t, int64(400), *metrics["cgroup.cpu.throttled_usec"].Delta)
+	assert.Equal(t, int64(200), *metrics["cgroup.io.rbytes"].Delta)
+	assert.Equal(t, int64(0), *metrics["cgroup.io.wbytes"].Delta)
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Collector is implemented by every source of agent metrics.
+// Collect puts freshly gathered values into provided storage.
+type Collector interface {
+	Name() string
+	Collect(m *MemStorage) error
+}
+
+type registryEntry struct {
+	collector Collector
+	interval  time.Duration
+	nextRun   time.Time
+}
+
+// schedule sets the next run one interval after the expected one, so ticker jitter doesn't shift the schedule.
+// Runs missed while agent was suspended or collectors were slow aren't caught up.
+func (e *registryEntry) schedule(now time.Time) {
+	if e.nextRun.IsZero() {
+		e.nextRun = now
+	}
+	e.nextRun = e.nextRun.Add(e.interval)
+	if !e.nextRun.After(now) {
+		e.nextRun = now.Add(e.interval)
+	}
+}
+
+// Registry keeps registered collectors with their poll intervals
+// and runs them against one MemStorage.
+type Registry struct {
+	entries    []*registryEntry
+	memStorage *MemStorage
+}
+
+// NewRegistry returns Registry object filling provided storage.
+func NewRegistry(memStorage *MemStorage) *Registry {
+	return &Registry{memStorage: memStorage}
+}
+
+// Register adds collector to the registry. Collector runs not often than once per interval.
+func (r *Registry) Register(c Collector, interval time.Duration) {
+	r.entries = append(r.entries, &registryEntry{collector: c, interval: interval})
+}
+
+// Names returns names of registered collectors in registration order.
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.entries))
+	for i, e := range r.entries {
+		names[i] = e.collector.Name()
+	}
+	return names
+}
+
+// MinInterval returns the smallest poll interval among registered collectors,
+// it is used as agent collect ticker period.
+func (r *Registry) MinInterval() time.Duration {
+	var minInterval time.Duration
+	for _, e := range r.entries {
+		if minInterval == 0 || e.interval < minInterval {
+			minInterval = e.interval
+		}
+	}
+	return minInterval
+}
+
+// Collect runs collectors which next run is due by the moment now. Ticker runs at the minimal interval,
+// so half of it is tolerated, otherwise a tick coming a bit earlier than expected would skip the poll.
+func (r *Registry) Collect(now time.Time) {
+	tolerance := r.MinInterval() / 2
+	for _, e := range r.entries {
+		if !e.nextRun.IsZero() && now.Add(tolerance).Before(e.nextRun) {
+			continue
+		}
+		e.schedule(now)
+		if err := r.run(e.collector); err != nil {
+			log.Printf("collector %s failed: %+v", e.collector.Name(), err)
+		}
+	}
+}
+
+// CollectAll runs every registered collector regardless of its poll interval.
+func (r *Registry) CollectAll() {
+	now := time.Now()
+	for _, e := range r.entries {
+		e.schedule(now)
+		if err := r.run(e.collector); err != nil {
+			log.Printf("collector %s failed: %+v", e.collector.Name(), err)
+		}
+	}
+}
+
+// waiter is implemented by collectors which finish collecting in background.
+type waiter interface {
+	Wait(ctx context.Context) error
+}
+
+// Wait waits for collectors running in background to finish, but not longer than ctx allows.
+func (r *Registry) Wait(ctx context.Context) error {
+	var errs []error
+	for _, e := range r.entries {
+		if c, ok := e.collector.(waiter); ok {
+			errs = append(errs, c.Wait(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close releases resources of collectors holding files or sockets open.
+func (r *Registry) Close() error {
+	var errs []error
+	for _, e := range r.entries {
+		if c, ok := e.collector.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run isolates collector failures, so panic in one collector doesn't stop the others.
+func (r *Registry) run(c Collector) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+
+	return c.Collect(r.memStorage)
+}
+
+// deltaTracker keeps previous values of monotonic OS counters
+// to turn them into deltas suitable for counter metrics.
+type deltaTracker map[string]uint64
+
+// Delta returns difference between current and previous value of the counter.
+// The first observation has no previous value, so false is returned.
+// If counter went backwards (device reset, overflow) the current value is considered as delta.
+func (d deltaTracker) Delta(name string, current uint64) (int64, bool) {
+	prev, ok := d[name]
+	d[name] = current
+	if !ok {
+		return 0, false
+	}
+	if current < prev {
+		return int64(current), true
+	}
+	return int64(current - prev), true
+}
+
+var metricNameReplacer = strings.NewReplacer("/", "_", " ", "_", ".", "_", ":", "_", "\\", "_")
+
+// metricName joins parts with dots, replacing separators inside every part,
+// so mountpoints or device names can be used in metric ID.
+func metricName(parts ...string) string {
+	for i, p := range parts {
+		p = metricNameReplacer.Replace(strings.Trim(p, "/"))
+		if p == "" {
+			p = "root"
+		}
+		parts[i] = p
+	}
+	return strings.Join(parts, ".")
+}
+
+type runtimeCollector struct{}
+
+// NewRuntimeCollector returns collector of runtime.MemStats values, PollCount and RandomValue.
+func NewRuntimeCollector() Collector {
+	return runtimeCollector{}
+}
+
+func (runtimeCollector) Name() string {
+	return "runtime"
+}
+
+func (runtimeCollector) Collect(m *MemStorage) error {
+	m.GarbageStats()
+	return nil
+}
+
+type systemCollector struct{}
+
+// NewSystemCollector returns collector of virtual memory values.
+func NewSystemCollector() Collector {
+	return systemCollector{}
+}
+
+func (systemCollector) Name() string {
+	return "system"
+}
+
+func (systemCollector) Collect(m *MemStorage) error {
+	return m.collectVirtualMemory()
+}
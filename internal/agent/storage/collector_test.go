@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCollector struct {
+	name  string
+	calls int
+	err   error
+	panic bool
+}
+
+func (f *fakeCollector) Name() string {
+	return f.name
+}
+
+func (f *fakeCollector) Collect(m *MemStorage) error {
+	f.calls++
+	if f.panic {
+		panic("broken collector")
+	}
+	m.SetGauge(f.name, float64(f.calls))
+	return f.err
+}
+
+func TestRegistry(t *testing.T) {
+	t.Run("runs collectors by their intervals", func(t *testing.T) {
+		memStorage := NewMemStorage()
+		registry := NewRegistry(&memStorage)
+		fast := &fakeCollector{name: "fast"}
+		slow := &fakeCollector{name: "slow"}
+		registry.Register(fast, time.Second)
+		registry.Register(slow, 5*time.Second)
+
+		assert.Equal(t, time.Second, registry.MinInterval())
+		assert.Equal(t, []string{"fast", "slow"}, registry.Names())
+
+		start := time.Now()
+		for i := 0; i < 6; i++ {
+			registry.Collect(start.Add(time.Duration(i) * time.Second))
+		}
+
+		assert.Equal(t, 6, fast.calls)
+		assert.Equal(t, 2, slow.calls)
+	})
+
+	t.Run("ticker jitter doesn't skip polls", func(t *testing.T) {
+		memStorage := NewMemStorage()
+		registry := NewRegistry(&memStorage)
+		fast := &fakeCollector{name: "fast"}
+		slow := &fakeCollector{name: "slow"}
+		registry.Register(fast, 50*time.Millisecond)
+		registry.Register(slow, 250*time.Millisecond)
+
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			jitter := 5 * time.Millisecond
+			if i%2 == 0 {
+				jitter = -jitter
+			}
+			registry.Collect(start.Add(time.Duration(i)*50*time.Millisecond + jitter))
+		}
+
+		assert.Equal(t, 100, fast.calls)
+		assert.Equal(t, 20, slow.calls)
+	})
+
+	t.Run("isolates collector failures", func(t *testing.T) {
+		memStorage := NewMemStorage()
+		registry := NewRegistry(&memStorage)
+		failing := &fakeCollector{name: "failing", err: errors.New("no data")}
+		panicking := &fakeCollector{name: "panicking", panic: true}
+		healthy := &fakeCollector{name: "healthy"}
+		registry.Register(failing, time.Second)
+		registry.Register(panicking, time.Second)
+		registry.Register(healthy, time.Second)
+
+		registry.CollectAll()
+
+		var metricNames []string
+		for _, mt := range memStorage.GetAllMetrics() {
+			metricNames = append(metricNames, mt.ID)
+		}
+		assert.Contains(t, metricNames, "healthy")
+		assert.Equal(t, 1, panicking.calls)
+	})
+}
+
+type closingCollector struct {
+	fakeCollector
+	closed bool
+}
+
+func (c *closingCollector) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRegistryClose(t *testing.T) {
+	memStorage := NewMemStorage()
+	registry := NewRegistry(&memStorage)
+	closing := &closingCollector{fakeCollector: fakeCollector{name: "closing"}}
+	registry.Register(closing, time.Second)
+	registry.Register(&fakeCollector{name: "plain"}, time.Second)
+
+	assert.NoError(t, registry.Close())
+	assert.True(t, closing.closed)
+}
+
+func TestDeltaTracker(t *testing.T) {
+	d := deltaTracker{}
+
+	_, ok := d.Delta("bytes", 100)
+	assert.False(t, ok)
+
+	delta, ok := d.Delta("bytes", 150)
+	assert.True(t, ok)
+	assert.Equal(t, int64(50), delta)
+
+	delta, ok = d.Delta("bytes", 20)
+	assert.True(t, ok)
+	assert.Equal(t, int64(20), delta, "counter reset is treated as restart from zero")
+}
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "disk.root.total", metricName("disk", "/", "total"))
+	assert.Equal(t, "disk.var_lib_docker.free", metricName("disk", "/var/lib/docker", "free"))
+	assert.Equal(t, "net.eth0_1.bytes_sent", metricName("net", "eth0.1", "bytes_sent"))
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+)
+
+// CPUCollector computes CPU utilization from the difference between two cpu.Times snapshots.
+// Cores are identified by the name OS gives them, so metric IDs stay stable across polls.
+type CPUCollector struct {
+	perCore   bool
+	aggregate bool
+	previous  map[string]cpu.TimesStat
+
+	times func(perCPU bool) ([]cpu.TimesStat, error)
+}
+
+// NewCPUCollector returns CPUCollector object reporting logical cores, aggregate over all cores or both.
+func NewCPUCollector(perCore, aggregate bool) *CPUCollector {
+	return &CPUCollector{
+		perCore:   perCore,
+		aggregate: aggregate,
+		previous:  map[string]cpu.TimesStat{},
+		times:     cpu.Times,
+	}
+}
+
+// Name returns collector name used in configuration.
+func (c *CPUCollector) Name() string {
+	return "cpu"
+}
+
+// Collect saves cpu.<core>.{user,system,iowait,steal,idle} percent gauges, where core is
+// logical core number or "total" for the aggregate. Legacy CPUutilization<core> gauges
+// keep busy percent of every logical core, so they are reported only if cores are reported,
+// there is no legacy aggregate gauge. The first poll only remembers the snapshot.
+func (c *CPUCollector) Collect(m *MemStorage) error {
+	if c.perCore {
+		perCPU, err := c.times(true)
+		if err != nil {
+			return err
+		}
+		for _, t := range perCPU {
+			core := strings.TrimPrefix(t.CPU, "cpu")
+			if idle, ok := c.report(m, core, t); ok {
+				m.SetGauge("CPUutilization"+core, 100-idle)
+			}
+		}
+	}
+
+	if c.aggregate {
+		total, err := c.times(false)
+		if err != nil {
+			return err
+		}
+		if len(total) > 0 {
+			c.report(m, "total", total[0])
+		}
+	}
+
+	return nil
+}
+
+// report saves percentages for one core and returns its idle percent.
+func (c *CPUCollector) report(m *MemStorage, core string, current cpu.TimesStat) (float64, bool) {
+	prev, ok := c.previous[core]
+	c.previous[core] = current
+	if !ok {
+		return 0, false
+	}
+
+	elapsed := busyAndIdle(current) - busyAndIdle(prev)
+	if elapsed <= 0 {
+		return 0, false
+	}
+
+	percent := func(cur, old float64) float64 {
+		p := (cur - old) / elapsed * 100
+		if p < 0 {
+			return 0
+		}
+		return p
+	}
+
+	idle := percent(current.Idle, prev.Idle)
+	m.SetGauge(metricName("cpu", core, "user"), percent(current.User+current.Nice, prev.User+prev.Nice))
+	m.SetGauge(metricName("cpu", core, "system"), percent(current.System+current.Irq+current.Softirq, prev.System+prev.Irq+prev.Softirq))
+	m.SetGauge(metricName("cpu", core, "iowait"), percent(current.Iowait, prev.Iowait))
+	m.SetGauge(metricName("cpu", core, "steal"), percent(current.Steal, prev.Steal))
+	m.SetGauge(metricName("cpu", core, "idle"), idle)
+
+	return idle, true
+}
+
+// busyAndIdle sums all CPU times. Guest time is already accounted in user time on Linux.
+func busyAndIdle(t cpu.TimesStat) float64 {
+	return t.User + t.Nice + t.System + t.Irq + t.Softirq + t.Iowait + t.Steal + t.Idle
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUCollector(t *testing.T) {
+	perCPU := []cpu.TimesStat{
+		{CPU: "cpu0", User: 100, System: 50, Idle: 800, Iowait: 10},
+		{CPU: "cpu1", User: 200, System: 50, Idle: 700, Steal: 5},
+	}
+	total := []cpu.TimesStat{{CPU: "cpu-total", User: 300, System: 100, Idle: 1500, Iowait: 10, Steal: 5}}
+
+	c := NewCPUCollector(true, true)
+	c.times = func(perCore bool) ([]cpu.TimesStat, error) {
+		if perCore {
+			return perCPU, nil
+		}
+		return total, nil
+	}
+
+	memStorage := NewMemStorage()
+	require.NoError(t, c.Collect(&memStorage))
+	assert.Empty(t, memStorage.GetAllMetrics(), "first poll only remembers snapshot")
+
+	// cpu0 spent 100 ticks: 30 user, 10 system, 50 idle, 10 iowait
+	perCPU[0] = cpu.TimesStat{CPU: "cpu0", User: 130, System: 60, Idle: 850, Iowait: 20}
+	// cpu1 spent 100 ticks idle only
+	perCPU[1] = cpu.TimesStat{CPU: "cpu1", User: 200, System: 50, Idle: 800, Steal: 5}
+	total[0] = cpu.TimesStat{CPU: "cpu-total", User: 330, System: 110, Idle: 1650, Iowait: 20, Steal: 5}
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics := metricsByID(&memStorage)
+	assert.InDelta(t, 30, *metrics["cpu.0.user"].Value, 0.001)
+	assert.InDelta(t, 10, *metrics["cpu.0.system"].Value, 0.001)
+	assert.InDelta(t, 10, *metrics["cpu.0.iowait"].Value, 0.001)
+	assert.InDelta(t, 50, *metrics["cpu.0.idle"].Value, 0.001)
+	assert.InDelta(t, 50, *metrics["CPUutilization0"].Value, 0.001)
+	assert.InDelta(t, 100, *metrics["cpu.1.idle"].Value, 0.001)
+	assert.InDelta(t, 0, *metrics["CPUutilization1"].Value, 0.001)
+	assert.InDelta(t, 15, *metrics["cpu.total.user"].Value, 0.001)
+	assert.InDelta(t, 75, *metrics["cpu.total.idle"].Value, 0.001)
+
+	t.Run("aggregate only", func(t *testing.T) {
+		aggregateOnly := NewCPUCollector(false, true)
+		aggregateOnly.times = c.times
+		onlyTotal := NewMemStorage()
+		require.NoError(t, aggregateOnly.Collect(&onlyTotal))
+		total[0].Idle += 100
+		require.NoError(t, aggregateOnly.Collect(&onlyTotal))
+
+		metrics := metricsByID(&onlyTotal)
+		assert.InDelta(t, 100, *metrics["cpu.total.idle"].Value, 0.001)
+		assert.NotContains(t, metrics, "cpu.0.idle")
+	})
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"slices"
+
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+// DiskCollector gathers filesystem usage gauges per mountpoint
+// and I/O counters per block device.
+type DiskCollector struct {
+	includeFsTypes []string
+	excludeFsTypes []string
+	io             deltaTracker
+
+	partitions func(all bool) ([]disk.PartitionStat, error)
+	usage      func(path string) (*disk.UsageStat, error)
+	ioCounters func(names ...string) (map[string]disk.IOCountersStat, error)
+}
+
+// NewDiskCollector returns DiskCollector object. If includeFsTypes is not empty,
+// only filesystems of these types are reported, excludeFsTypes are skipped anyway.
+func NewDiskCollector(includeFsTypes, excludeFsTypes []string) *DiskCollector {
+	return &DiskCollector{
+		includeFsTypes: includeFsTypes,
+		excludeFsTypes: excludeFsTypes,
+		io:             deltaTracker{},
+		partitions:     disk.Partitions,
+		usage:          disk.Usage,
+		ioCounters:     disk.IOCounters,
+	}
+}
+
+// Name returns collector name used in configuration.
+func (c *DiskCollector) Name() string {
+	return "disk"
+}
+
+// Collect saves disk.<mountpoint>.* gauges and diskio.<device>.* counters.
+func (c *DiskCollector) Collect(m *MemStorage) error {
+	partitions, err := c.partitions(false)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partitions {
+		if !c.fsTypeAllowed(p.Fstype) {
+			continue
+		}
+
+		usage, err := c.usage(p.Mountpoint)
+		if err != nil {
+			continue
+		}
+
+		m.SetGauge(metricName("disk", p.Mountpoint, "total"), float64(usage.Total))
+		m.SetGauge(metricName("disk", p.Mountpoint, "used"), float64(usage.Used))
+		m.SetGauge(metricName("disk", p.Mountpoint, "free"), float64(usage.Free))
+		m.SetGauge(metricName("disk", p.Mountpoint, "inodes_used"), float64(usage.InodesUsed))
+		m.SetGauge(metricName("disk", p.Mountpoint, "inodes_free"), float64(usage.InodesFree))
+	}
+
+	counters, err := c.ioCounters()
+	if err != nil {
+		return err
+	}
+
+	for device, io := range counters {
+		values := map[string]uint64{
+			"read_bytes":  io.ReadBytes,
+			"write_bytes": io.WriteBytes,
+			"read_count":  io.ReadCount,
+			"write_count": io.WriteCount,
+			"io_time":     io.IoTime,
+		}
+		for field, value := range values {
+			name := metricName("diskio", device, field)
+			if delta, ok := c.io.Delta(name, value); ok {
+				m.AddCounter(name, delta)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *DiskCollector) fsTypeAllowed(fsType string) bool {
+	if slices.Contains(c.excludeFsTypes, fsType) {
+		return false
+	}
+
+	return len(c.includeFsTypes) == 0 || slices.Contains(c.includeFsTypes, fsType)
+}
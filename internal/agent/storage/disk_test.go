@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v4/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aykuli/observer/internal/models"
+)
+
+func metricsByID(memStorage *MemStorage) map[string]models.Metric {
+	out := map[string]models.Metric{}
+	for _, mt := range memStorage.GetAllMetrics() {
+		out[mt.ID] = mt
+	}
+	return out
+}
+
+func TestDiskCollector(t *testing.T) {
+	readBytes := uint64(1000)
+	c := NewDiskCollector(nil, []string{"tmpfs"})
+	c.partitions = func(all bool) ([]disk.PartitionStat, error) {
+		return []disk.PartitionStat{
+			{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"},
+			{Device: "/dev/sda2", Mountpoint: "/var/lib", Fstype: "ext4"},
+			{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs"},
+		}, nil
+	}
+	c.usage = func(path string) (*disk.UsageStat, error) {
+		return &disk.UsageStat{Path: path, Total: 100, Used: 60, Free: 40, InodesUsed: 5, InodesFree: 7}, nil
+	}
+	c.ioCounters = func(names ...string) (map[string]disk.IOCountersStat, error) {
+		return map[string]disk.IOCountersStat{"sda": {ReadBytes: readBytes}}, nil
+	}
+
+	memStorage := NewMemStorage()
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics := metricsByID(&memStorage)
+	require.Contains(t, metrics, "disk.root.total")
+	assert.Equal(t, float64(60), *metrics["disk.var_lib.used"].Value)
+	assert.Equal(t, float64(7), *metrics["disk.root.inodes_free"].Value)
+	assert.NotContains(t, metrics, "disk.run.total")
+	assert.NotContains(t, metrics, "diskio.sda.read_bytes", "first poll only remembers counters")
+
+	readBytes = 1500
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics = metricsByID(&memStorage)
+	require.Contains(t, metrics, "diskio.sda.read_bytes")
+	assert.Equal(t, int64(500), *metrics["diskio.sda.read_bytes"].Delta)
+	assert.Equal(t, "counter", metrics["diskio.sda.read_bytes"].MType)
+}
+
+func TestFsTypeAllowed(t *testing.T) {
+	c := NewDiskCollector([]string{"ext4", "tmpfs"}, []string{"tmpfs"})
+	assert.True(t, c.fsTypeAllowed("ext4"))
+	assert.False(t, c.fsTypeAllowed("tmpfs"))
+	assert.False(t, c.fsTypeAllowed("xfs"))
+}
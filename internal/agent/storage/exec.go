@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aykuli/observer/internal/models"
+)
+
+// ExecTimeoutDefault limits command run time if timeout isn't configured.
+const ExecTimeoutDefault = 10 * time.Second
+
+// ExecCollector runs external command and saves metrics printed to its stdout.
+// Output is either JSON array of metrics as server /updates/ endpoint accepts
+// or lines in "name type value" format, empty lines and lines started with # are skipped.
+// Command runs in background, so slow command doesn't delay other collectors.
+type ExecCollector struct {
+	name    string
+	command []string
+	timeout time.Duration
+
+	running atomic.Bool
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewExecCollector returns ExecCollector object running command with arguments.
+func NewExecCollector(name string, command []string, timeout time.Duration) (*ExecCollector, error) {
+	if name == "" {
+		return nil, errors.New("exec collector name is empty")
+	}
+	if len(command) == 0 {
+		return nil, errors.New("exec collector " + name + " has no command")
+	}
+	if timeout <= 0 {
+		timeout = ExecTimeoutDefault
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ExecCollector{name: name, command: command, timeout: timeout, ctx: ctx, cancel: cancel}, nil
+}
+
+// Name returns collector name used in configuration, it is "exec.<name>".
+func (c *ExecCollector) Name() string {
+	return "exec." + c.name
+}
+
+// Collect starts command and returns without waiting for it. Parsed metrics are merged into storage
+// when command finishes, its failure is logged. Command isn't started while the previous run is in progress.
+func (c *ExecCollector) Collect(m *MemStorage) error {
+	if !c.running.CompareAndSwap(false, true) {
+		return errors.New("previous run is still in progress")
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer c.running.Store(false)
+		if err := c.run(m); err != nil {
+			log.Printf("collector %s failed: %+v", c.Name(), err)
+		}
+	}()
+
+	return nil
+}
+
+// Wait waits for running command to finish and its metrics to be merged, but not longer than ctx allows.
+func (c *ExecCollector) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("collector %s: %w", c.Name(), ctx.Err())
+	}
+}
+
+// Close kills running command and waits for it to exit.
+func (c *ExecCollector) Close() error {
+	c.cancel()
+	c.wg.Wait()
+	return nil
+}
+
+// run runs command and merges parsed metrics into storage.
+func (c *ExecCollector) run(m *MemStorage) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.command[0], c.command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if c.ctx.Err() != nil {
+			return errors.New("command was killed because collector is closed")
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("command timed out after %s", c.timeout)
+		}
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	metrics, err := parseExecOutput(stdout.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return m.SaveMetrics(metrics)
+}
+
+func parseExecOutput(output []byte) ([]models.Metric, error) {
+	trimmed := bytes.TrimSpace(output)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	if trimmed[0] == '[' {
+		var metrics []models.Metric
+		if err := json.Unmarshal(trimmed, &metrics); err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	}
+
+	var metrics []models.Metric
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected \"name type value\", got %q", line, text)
+		}
+
+		metric := models.Metric{ID: fields[0], MType: fields[1]}
+		switch fields[1] {
+		case "gauge":
+			value, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			metric.Value = &value
+		case "counter":
+			delta, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			metric.Delta = &delta
+		default:
+			return nil, fmt.Errorf("line %d: no such metric type %q", line, fields[1])
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, scanner.Err()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	t.Run("line format", func(t *testing.T) {
+		metrics, err := parseExecOutput([]byte("# queue check\nqueue.size gauge 12.5\n\nqueue.errors counter 3\n"))
+		require.NoError(t, err)
+		require.Len(t, metrics, 2)
+		assert.Equal(t, 12.5, *metrics[0].Value)
+		assert.Equal(t, int64(3), *metrics[1].Delta)
+	})
+
+	t.Run("json format", func(t *testing.T) {
+		metrics, err := parseExecOutput([]byte(`[{"id":"queue.size","type":"gauge","value":7}]`))
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		assert.Equal(t, float64(7), *metrics[0].Value)
+	})
+
+	t.Run("wrong lines", func(t *testing.T) {
+		_, err := parseExecOutput([]byte("queue.size gauge"))
+		assert.Error(t, err)
+		_, err = parseExecOutput([]byte("queue.size histogram 1"))
+		assert.Error(t, err)
+		_, err = parseExecOutput([]byte("queue.size counter 1.5"))
+		assert.Error(t, err)
+	})
+}
+
+func TestExecCollector(t *testing.T) {
+	t.Run("merges command output", func(t *testing.T) {
+		c, err := NewExecCollector("queue", []string{"sh", "-c", "echo 'queue.size gauge 4'; echo 'queue.errors counter 2'"}, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "exec.queue", c.Name())
+
+		memStorage := NewMemStorage()
+		require.NoError(t, c.run(&memStorage))
+		require.NoError(t, c.run(&memStorage))
+
+		metrics := metricsByID(&memStorage)
+		assert.Equal(t, float64(4), *metrics["queue.size"].Value)
+		assert.Equal(t, int64(4), *metrics["queue.errors"].Delta)
+	})
+
+	t.Run("kills command on timeout", func(t *testing.T) {
+		c, err := NewExecCollector("slow", []string{"sleep", "5"}, 100*time.Millisecond)
+		require.NoError(t, err)
+
+		memStorage := NewMemStorage()
+		start := time.Now()
+		assert.Error(t, c.run(&memStorage))
+		assert.Less(t, time.Since(start), 2*time.Second)
+	})
+
+	t.Run("failed command", func(t *testing.T) {
+		c, err := NewExecCollector("fail", []string{"sh", "-c", "echo 'broken' >&2; exit 1"}, time.Second)
+		require.NoError(t, err)
+
+		memStorage := NewMemStorage()
+		assert.ErrorContains(t, c.run(&memStorage), "broken")
+	})
+
+	t.Run("runs in background", func(t *testing.T) {
+		c, err := NewExecCollector("background", []string{"sh", "-c", "sleep 0.2; echo 'queue.size gauge 4'"}, time.Second)
+		require.NoError(t, err)
+
+		memStorage := NewMemStorage()
+		start := time.Now()
+		require.NoError(t, c.Collect(&memStorage))
+		assert.Error(t, c.Collect(&memStorage), "previous run is in progress")
+		assert.Less(t, time.Since(start), 100*time.Millisecond, "collect doesn't wait for command")
+
+		assert.Eventually(t, func() bool {
+			_, ok := metricsByID(&memStorage)["queue.size"]
+			return ok
+		}, time.Second, 10*time.Millisecond)
+		require.NoError(t, c.Close())
+	})
+
+	t.Run("close kills running command", func(t *testing.T) {
+		c, err := NewExecCollector("slow", []string{"sleep", "5"}, 10*time.Second)
+		require.NoError(t, err)
+
+		memStorage := NewMemStorage()
+		start := time.Now()
+		require.NoError(t, c.Collect(&memStorage))
+		require.NoError(t, c.Close())
+		assert.Less(t, time.Since(start), 2*time.Second)
+		assert.ErrorContains(t, c.run(&memStorage), "collector is closed", "killing on close isn't reported as timeout")
+	})
+
+	t.Run("wait for running command", func(t *testing.T) {
+		c, err := NewExecCollector("background", []string{"sh", "-c", "sleep 0.2; echo 'queue.size gauge 4'"}, time.Second)
+		require.NoError(t, err)
+		defer c.Close()
+
+		memStorage := NewMemStorage()
+		require.NoError(t, c.Collect(&memStorage))
+		require.NoError(t, c.Wait(context.Background()))
+		assert.Contains(t, metricsByID(&memStorage), "queue.size")
+	})
+
+	t.Run("wait is bounded by context", func(t *testing.T) {
+		c, err := NewExecCollector("slow", []string{"sleep", "5"}, 10*time.Second)
+		require.NoError(t, err)
+		defer c.Close()
+
+		memStorage := NewMemStorage()
+		require.NoError(t, c.Collect(&memStorage))
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, c.Wait(ctx), context.DeadlineExceeded)
+	})
+}
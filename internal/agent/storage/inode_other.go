@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// fileInode returns zero on systems without inodes, so rotation is detected by truncation only.
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
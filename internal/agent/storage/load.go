@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"github.com/shirou/gopsutil/v4/host"
+	"github.com/shirou/gopsutil/v4/load"
+)
+
+// LoadCollector gathers load average, host uptime, boot time and process counts.
+type LoadCollector struct {
+	counters deltaTracker
+
+	avg      func() (*load.AvgStat, error)
+	misc     func() (*load.MiscStat, error)
+	uptime   func() (uint64, error)
+	bootTime func() (uint64, error)
+}
+
+// NewLoadCollector returns LoadCollector object.
+func NewLoadCollector() *LoadCollector {
+	return &LoadCollector{
+		counters: deltaTracker{},
+		avg:      load.Avg,
+		misc:     load.Misc,
+		uptime:   host.Uptime,
+		bootTime: host.BootTime,
+	}
+}
+
+// Name returns collector name used in configuration.
+func (c *LoadCollector) Name() string {
+	return "load"
+}
+
+// Collect saves load.* and host.* gauges and host.context_switches, host.procs_created counters.
+func (c *LoadCollector) Collect(m *MemStorage) error {
+	avg, err := c.avg()
+	if err != nil {
+		return err
+	}
+	m.SetGauge("load.load1", avg.Load1)
+	m.SetGauge("load.load5", avg.Load5)
+	m.SetGauge("load.load15", avg.Load15)
+
+	uptime, err := c.uptime()
+	if err != nil {
+		return err
+	}
+	m.SetGauge("host.uptime", float64(uptime))
+
+	bootTime, err := c.bootTime()
+	if err != nil {
+		return err
+	}
+	m.SetGauge("host.boot_time", float64(bootTime))
+
+	misc, err := c.misc()
+	if err != nil {
+		return err
+	}
+	m.SetGauge("host.procs_total", float64(misc.ProcsTotal))
+	m.SetGauge("host.procs_running", float64(misc.ProcsRunning))
+	m.SetGauge("host.procs_blocked", float64(misc.ProcsBlocked))
+
+	if delta, ok := c.counters.Delta("host.context_switches", uint64(misc.Ctxt)); ok {
+		m.AddCounter("host.context_switches", delta)
+	}
+	if delta, ok := c.counters.Delta("host.procs_created", uint64(misc.ProcsCreated)); ok {
+		m.AddCounter("host.procs_created", delta)
+	}
+
+	return nil
+}
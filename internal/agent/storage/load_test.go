@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v4/load"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCollector(t *testing.T) {
+	misc := load.MiscStat{ProcsTotal: 300, ProcsRunning: 4, ProcsBlocked: 1, Ctxt: 1000}
+	c := NewLoadCollector()
+	c.avg = func() (*load.AvgStat, error) {
+		return &load.AvgStat{Load1: 0.5, Load5: 0.25, Load15: 0.1}, nil
+	}
+	c.misc = func() (*load.MiscStat, error) {
+		return &misc, nil
+	}
+	c.uptime = func() (uint64, error) { return 3600, nil }
+	c.bootTime = func() (uint64, error) { return 1700000000, nil }
+
+	memStorage := NewMemStorage()
+	require.NoError(t, c.Collect(&memStorage))
+	misc.Ctxt = 1600
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics := metricsByID(&memStorage)
+	assert.Equal(t, 0.5, *metrics["load.load1"].Value)
+	assert.Equal(t, 0.1, *metrics["load.load15"].Value)
+	assert.Equal(t, float64(3600), *metrics["host.uptime"].Value)
+	assert.Equal(t, float64(1700000000), *metrics["host.boot_time"].Value)
+	assert.Equal(t, float64(4), *metrics["host.procs_running"].Value)
+	assert.Equal(t, float64(1), *metrics["host.procs_blocked"].Value)
+	assert.Equal(t, int64(600), *metrics["host.context_switches"].Delta)
+}
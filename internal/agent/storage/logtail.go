@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// LogPattern describes regular expression looked for in log lines.
+// Every matched line increments Counter metric. If Gauge is set, captured Group
+// (the first one by default) is parsed as number and saved into Gauge metric.
+type LogPattern struct {
+	Counter string
+	Regex   string
+	Gauge   string
+	Group   int
+}
+
+// LogFile describes log file tailed by LogTailCollector.
+type LogFile struct {
+	Path     string
+	Patterns []LogPattern
+}
+
+type compiledPattern struct {
+	LogPattern
+	re *regexp.Regexp
+}
+
+// logPosition is persisted between agent restarts.
+type logPosition struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+type tailedFile struct {
+	path     string
+	patterns []compiledPattern
+	file     *os.File
+	position logPosition
+}
+
+// LogTailCollector follows log files, survives rotation by tracking inodes
+// and turns regular expression matches into metrics.
+type LogTailCollector struct {
+	files     []*tailedFile
+	stateFile string
+}
+
+// NewLogTailCollector returns LogTailCollector object. Read positions are saved into stateFile
+// after every poll and restored on start, so lines aren't counted twice after restart.
+// Files without saved position are followed from their end.
+func NewLogTailCollector(files []LogFile, stateFile string) (*LogTailCollector, error) {
+	c := &LogTailCollector{stateFile: stateFile}
+	state := c.loadState()
+
+	for _, lf := range files {
+		if lf.Path == "" {
+			return nil, errors.New("log file path is empty")
+		}
+
+		tf := &tailedFile{path: lf.Path}
+		for _, p := range lf.Patterns {
+			if p.Counter == "" {
+				return nil, errors.New("log pattern counter name is empty for " + lf.Path)
+			}
+			re, err := regexp.Compile(p.Regex)
+			if err != nil {
+				return nil, err
+			}
+			if p.Gauge != "" && p.Group == 0 {
+				p.Group = 1
+			}
+			if p.Group > re.NumSubexp() {
+				return nil, errors.New("log pattern " + p.Counter + " has no group " + strconv.Itoa(p.Group))
+			}
+			tf.patterns = append(tf.patterns, compiledPattern{LogPattern: p, re: re})
+		}
+
+		if pos, ok := state[lf.Path]; ok {
+			tf.position = pos
+		} else if fi, err := os.Stat(lf.Path); err == nil {
+			tf.position = logPosition{Inode: fileInode(fi), Offset: fi.Size()}
+		}
+		c.files = append(c.files, tf)
+	}
+
+	return c, nil
+}
+
+// Name returns collector name used in configuration.
+func (c *LogTailCollector) Name() string {
+	return "logtail"
+}
+
+// Collect reads lines appended since previous poll and saves read positions.
+func (c *LogTailCollector) Collect(m *MemStorage) error {
+	var errs []error
+	for _, tf := range c.files {
+		if err := tf.poll(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := c.saveState(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close closes followed files.
+func (c *LogTailCollector) Close() error {
+	var errs []error
+	for _, tf := range c.files {
+		if tf.file != nil {
+			errs = append(errs, tf.file.Close())
+			tf.file = nil
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (tf *tailedFile) poll(m *MemStorage) error {
+	for _, p := range tf.patterns {
+		m.AddCounter(p.Counter, 0)
+	}
+
+	fi, err := os.Stat(tf.path)
+	if err != nil {
+		// File is moved away and the new one isn't created yet, finish the old one.
+		if tf.file != nil {
+			return tf.read(m)
+		}
+		return err
+	}
+
+	inode := fileInode(fi)
+	if tf.file != nil && inode != tf.position.Inode {
+		if err = tf.read(m); err != nil {
+			return err
+		}
+		tf.file.Close()
+		tf.file = nil
+		tf.position = logPosition{Inode: inode}
+	}
+
+	if tf.file == nil {
+		if tf.file, err = os.Open(tf.path); err != nil {
+			return err
+		}
+		if inode != tf.position.Inode {
+			// Rotated while agent was stopped, the new file is read from the beginning.
+			tf.position = logPosition{Inode: inode}
+		}
+	}
+
+	if fi.Size() < tf.position.Offset {
+		// File is truncated in place.
+		tf.position.Offset = 0
+	}
+
+	return tf.read(m)
+}
+
+// read processes complete lines from saved offset till the end of file.
+func (tf *tailedFile) read(m *MemStorage) error {
+	if _, err := tf.file.Seek(tf.position.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(tf.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// Incomplete line is read again on the next poll.
+				return nil
+			}
+			return err
+		}
+
+		tf.position.Offset += int64(len(line))
+		tf.match(m, line)
+	}
+}
+
+func (tf *tailedFile) match(m *MemStorage, line []byte) {
+	for _, p := range tf.patterns {
+		groups := p.re.FindSubmatch(line)
+		if groups == nil {
+			continue
+		}
+
+		m.AddCounter(p.Counter, 1)
+		if p.Gauge == "" {
+			continue
+		}
+		if value, err := strconv.ParseFloat(string(groups[p.Group]), 64); err == nil {
+			m.SetGauge(p.Gauge, value)
+		}
+	}
+}
+
+func (c *LogTailCollector) loadState() map[string]logPosition {
+	state := map[string]logPosition{}
+	if c.stateFile == "" {
+		return state
+	}
+
+	data, err := os.ReadFile(c.stateFile)
+	if err != nil {
+		return state
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return map[string]logPosition{}
+	}
+	return state
+}
+
+// saveState writes positions into temporary file and renames it, so state file is never half-written.
+func (c *LogTailCollector) saveState() error {
+	if c.stateFile == "" {
+		return nil
+	}
+
+	state := make(map[string]logPosition, len(c.files))
+	for _, tf := range c.files {
+		state[tf.path] = tf.position
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.stateFile), filepath.Base(c.stateFile)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.stateFile)
+}
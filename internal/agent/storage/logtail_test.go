@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendLines(t *testing.T, path, lines string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(lines)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestLogTailCollector(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+	statePath := filepath.Join(dir, "state.json")
+	appendLines(t, logPath, "GET / 500 rt=1.0\n")
+
+	files := []LogFile{{
+		Path: logPath,
+		Patterns: []LogPattern{
+			{Counter: "nginx.5xx", Regex: ` 5\d\d `},
+			{Counter: "nginx.requests", Regex: `rt=([0-9.]+)`, Gauge: "nginx.request_time"},
+		},
+	}}
+	c, err := NewLogTailCollector(files, statePath)
+	require.NoError(t, err)
+	defer c.Close()
+
+	memStorage := NewMemStorage()
+	require.NoError(t, c.Collect(&memStorage))
+	metrics := metricsByID(&memStorage)
+	assert.Equal(t, int64(0), *metrics["nginx.5xx"].Delta, "existing lines are skipped on the first start")
+
+	appendLines(t, logPath, "GET / 200 rt=0.1\nGET /a 502 rt=0.3\nGET /b 503 rt=")
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics = metricsByID(&memStorage)
+	assert.Equal(t, int64(1), *metrics["nginx.5xx"].Delta, "incomplete line waits for the next poll")
+	assert.Equal(t, int64(2), *metrics["nginx.requests"].Delta)
+	assert.Equal(t, 0.3, *metrics["nginx.request_time"].Value)
+
+	t.Run("rotation", func(t *testing.T) {
+		appendLines(t, logPath, "0.2\n")
+		require.NoError(t, os.Rename(logPath, logPath+".1"))
+		appendLines(t, logPath, "GET / 500 rt=0.5\n")
+		memStorage.AckCounters(memStorage.GetAllMetrics())
+		require.NoError(t, c.Collect(&memStorage))
+
+		metrics = metricsByID(&memStorage)
+		assert.Equal(t, int64(2), *metrics["nginx.5xx"].Delta, "tail of rotated file and new file are both read")
+		assert.Equal(t, 0.5, *metrics["nginx.request_time"].Value)
+	})
+
+	t.Run("truncation", func(t *testing.T) {
+		require.NoError(t, os.Truncate(logPath, 0))
+		appendLines(t, logPath, "GET / 504 \n")
+		memStorage.AckCounters(memStorage.GetAllMetrics())
+		require.NoError(t, c.Collect(&memStorage))
+
+		metrics = metricsByID(&memStorage)
+		assert.Equal(t, int64(1), *metrics["nginx.5xx"].Delta)
+	})
+
+	t.Run("offsets survive restart", func(t *testing.T) {
+		require.NoError(t, c.Close())
+		appendLines(t, logPath, "GET / 500 \n")
+
+		restarted, err := NewLogTailCollector(files, statePath)
+		require.NoError(t, err)
+		defer restarted.Close()
+
+		restartedStorage := NewMemStorage()
+		require.NoError(t, restarted.Collect(&restartedStorage))
+		metrics = metricsByID(&restartedStorage)
+		assert.Equal(t, int64(1), *metrics["nginx.5xx"].Delta, "only lines appended while agent was stopped")
+	})
+
+	t.Run("invalid patterns", func(t *testing.T) {
+		_, err := NewLogTailCollector([]LogFile{{Path: logPath, Patterns: []LogPattern{{Counter: "bad", Regex: "("}}}}, "")
+		assert.Error(t, err)
+		_, err = NewLogTailCollector([]LogFile{{Path: logPath, Patterns: []LogPattern{{Counter: "c", Regex: "x", Gauge: "g"}}}}, "")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"github.com/shirou/gopsutil/v4/net"
+)
+
+// NetworkCollector gathers traffic, error and drop counters per network interface.
+type NetworkCollector struct {
+	counters deltaTracker
+
+	ioCounters func(pernic bool) ([]net.IOCountersStat, error)
+}
+
+// NewNetworkCollector returns NetworkCollector object.
+func NewNetworkCollector() *NetworkCollector {
+	return &NetworkCollector{
+		counters:   deltaTracker{},
+		ioCounters: net.IOCounters,
+	}
+}
+
+// Name returns collector name used in configuration.
+func (c *NetworkCollector) Name() string {
+	return "network"
+}
+
+// Collect saves net.<interface>.* counters. OS reports totals since interface start,
+// collector converts them into deltas since previous poll, so the agent sends only
+// increments which server sums up.
+func (c *NetworkCollector) Collect(m *MemStorage) error {
+	stats, err := c.ioCounters(true)
+	if err != nil {
+		return err
+	}
+
+	for _, nic := range stats {
+		values := map[string]uint64{
+			"bytes_sent":   nic.BytesSent,
+			"bytes_recv":   nic.BytesRecv,
+			"packets_sent": nic.PacketsSent,
+			"packets_recv": nic.PacketsRecv,
+			"errors_in":    nic.Errin,
+			"errors_out":   nic.Errout,
+			"drops_in":     nic.Dropin,
+			"drops_out":    nic.Dropout,
+		}
+		for field, value := range values {
+			name := metricName("net", nic.Name, field)
+			if delta, ok := c.counters.Delta(name, value); ok {
+				m.AddCounter(name, delta)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/shirou/gopsutil/v4/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkCollector(t *testing.T) {
+	stats := []net.IOCountersStat{
+		{Name: "eth0", BytesSent: 1000, BytesRecv: 2000, Dropin: 1},
+		{Name: "eth1", BytesSent: 10, BytesRecv: 20},
+	}
+	c := NewNetworkCollector()
+	c.ioCounters = func(pernic bool) ([]net.IOCountersStat, error) {
+		return stats, nil
+	}
+	memStorage := NewMemStorage()
+
+	require.NoError(t, c.Collect(&memStorage))
+	assert.NotContains(t, metricsByID(&memStorage), "net.eth0.bytes_sent")
+
+	stats[0].BytesSent = 1300
+	stats[0].Dropin = 3
+	stats[1].BytesRecv = 25
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics := metricsByID(&memStorage)
+	assert.Equal(t, "counter", metrics["net.eth0.bytes_sent"].MType)
+	assert.Equal(t, int64(300), *metrics["net.eth0.bytes_sent"].Delta)
+	assert.Equal(t, int64(2), *metrics["net.eth0.drops_in"].Delta)
+	assert.Equal(t, int64(5), *metrics["net.eth1.bytes_recv"].Delta)
+	assert.Equal(t, int64(0), *metrics["net.eth1.bytes_sent"].Delta)
+
+	t.Run("deltas survive counters reset after sending", func(t *testing.T) {
+		memStorage.AckCounters(memStorage.GetAllMetrics())
+
+		stats[0].BytesSent = 1350
+		require.NoError(t, c.Collect(&memStorage))
+		stats[0].BytesSent = 1400
+		require.NoError(t, c.Collect(&memStorage))
+
+		metrics = metricsByID(&memStorage)
+		assert.Equal(t, int64(100), *metrics["net.eth0.bytes_sent"].Delta)
+	})
+}
@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// ProcessMatcher describes group of processes to be tracked.
+// Process is an exact process name, Cmdline is a regular expression matched against
+// the whole command line and Pidfile is a path to file with pid. Matcher Name is used in metric IDs.
+type ProcessMatcher struct {
+	Name    string
+	Process string
+	Cmdline string
+	Pidfile string
+}
+
+type compiledMatcher struct {
+	ProcessMatcher
+	cmdline *regexp.Regexp
+}
+
+type processInfo struct {
+	name       string
+	cmdline    string
+	createTime int64
+}
+
+type processUsage struct {
+	rss     uint64
+	cpuTime float64
+	fds     int32
+	threads int32
+}
+
+type cpuSample struct {
+	cpuTime float64
+	at      time.Time
+}
+
+type groupLeader struct {
+	pid        int32
+	createTime int64
+}
+
+// ProcessCollector gathers resource usage of process groups matched by name, cmdline or pidfile.
+type ProcessCollector struct {
+	matchers []compiledMatcher
+	cpu      map[int32]cpuSample
+	leaders  map[string]groupLeader
+
+	pids     func() ([]int32, error)
+	describe func(pid int32) (processInfo, error)
+	usage    func(pid int32) (processUsage, error)
+	now      func() time.Time
+}
+
+// NewProcessCollector returns ProcessCollector object, error is returned if matcher is invalid.
+func NewProcessCollector(matchers []ProcessMatcher) (*ProcessCollector, error) {
+	c := &ProcessCollector{
+		cpu:      map[int32]cpuSample{},
+		leaders:  map[string]groupLeader{},
+		pids:     process.Pids,
+		describe: describeProcess,
+		usage:    processResourceUsage,
+		now:      time.Now,
+	}
+
+	for _, pm := range matchers {
+		if pm.Name == "" {
+			return nil, errors.New("process matcher name is empty")
+		}
+		if pm.Process == "" && pm.Cmdline == "" && pm.Pidfile == "" {
+			return nil, errors.New("process matcher " + pm.Name + " has no process, cmdline or pidfile")
+		}
+
+		cm := compiledMatcher{ProcessMatcher: pm}
+		if pm.Cmdline != "" {
+			re, err := regexp.Compile(pm.Cmdline)
+			if err != nil {
+				return nil, err
+			}
+			cm.cmdline = re
+		}
+		c.matchers = append(c.matchers, cm)
+	}
+
+	return c, nil
+}
+
+// Name returns collector name used in configuration.
+func (c *ProcessCollector) Name() string {
+	return "process"
+}
+
+// Collect saves proc.<matcher>.{count,rss,cpu_percent,open_fds,threads} gauges
+// and proc.<matcher>.restarts counter. Restart is detected when the oldest process of the group changes.
+func (c *ProcessCollector) Collect(m *MemStorage) error {
+	pids, err := c.pids()
+	if err != nil {
+		return err
+	}
+
+	infos := make(map[int32]processInfo, len(pids))
+	for _, pid := range pids {
+		info, err := c.describe(pid)
+		if err != nil {
+			continue
+		}
+		infos[pid] = info
+	}
+
+	now := c.now()
+	seen := map[int32]bool{}
+	for _, cm := range c.matchers {
+		matched := c.match(cm, infos)
+
+		var rss uint64
+		var cpuPercent float64
+		var fds, threads int32
+		var leader groupLeader
+		count := 0
+		for _, pid := range matched {
+			usage, err := c.usage(pid)
+			if err != nil {
+				continue
+			}
+			count++
+			rss += usage.rss
+			fds += usage.fds
+			threads += usage.threads
+
+			if prev, ok := c.cpu[pid]; ok {
+				if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+					cpuPercent += (usage.cpuTime - prev.cpuTime) / elapsed * 100
+				}
+			}
+			c.cpu[pid] = cpuSample{cpuTime: usage.cpuTime, at: now}
+			seen[pid] = true
+
+			info := infos[pid]
+			if leader.pid == 0 || info.createTime < leader.createTime {
+				leader = groupLeader{pid: pid, createTime: info.createTime}
+			}
+		}
+
+		m.SetGauge(metricName("proc", cm.Name, "count"), float64(count))
+		m.SetGauge(metricName("proc", cm.Name, "rss"), float64(rss))
+		m.SetGauge(metricName("proc", cm.Name, "cpu_percent"), cpuPercent)
+		m.SetGauge(metricName("proc", cm.Name, "open_fds"), float64(fds))
+		m.SetGauge(metricName("proc", cm.Name, "threads"), float64(threads))
+
+		restarts := metricName("proc", cm.Name, "restarts")
+		prevLeader, known := c.leaders[cm.Name]
+		if known && leader.pid != 0 && prevLeader != leader {
+			m.AddCounter(restarts, 1)
+		} else {
+			m.AddCounter(restarts, 0)
+		}
+		if leader.pid != 0 {
+			c.leaders[cm.Name] = leader
+		}
+	}
+
+	for pid := range c.cpu {
+		if !seen[pid] {
+			delete(c.cpu, pid)
+		}
+	}
+
+	return nil
+}
+
+func (c *ProcessCollector) match(cm compiledMatcher, infos map[int32]processInfo) []int32 {
+	var matched []int32
+
+	if cm.Pidfile != "" {
+		pid, err := readPidfile(cm.Pidfile)
+		if err != nil {
+			return nil
+		}
+		if _, ok := infos[pid]; ok {
+			matched = append(matched, pid)
+		}
+		return matched
+	}
+
+	for pid, info := range infos {
+		if cm.Process != "" && info.name != cm.Process {
+			continue
+		}
+		if cm.cmdline != nil && !cm.cmdline.MatchString(info.cmdline) {
+			continue
+		}
+		matched = append(matched, pid)
+	}
+
+	return matched
+}
+
+func readPidfile(path string) (int32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int32(pid), nil
+}
+
+func describeProcess(pid int32) (processInfo, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return processInfo{}, err
+	}
+
+	name, err := p.Name()
+	if err != nil {
+		return processInfo{}, err
+	}
+	cmdline, _ := p.Cmdline()
+	createTime, _ := p.CreateTime()
+
+	return processInfo{name: name, cmdline: cmdline, createTime: createTime}, nil
+}
+
+func processResourceUsage(pid int32) (processUsage, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return processUsage{}, err
+	}
+
+	var usage processUsage
+	memInfo, err := p.MemoryInfo()
+	if err != nil {
+		return usage, err
+	}
+	usage.rss = memInfo.RSS
+
+	times, err := p.Times()
+	if err != nil {
+		return usage, err
+	}
+	usage.cpuTime = times.User + times.System
+
+	// Open descriptors of foreign processes can be unavailable without privileges.
+	usage.fds, _ = p.NumFDs()
+	usage.threads, _ = p.NumThreads()
+
+	return usage, nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessCollector(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "nginx.pid")
+	require.NoError(t, os.WriteFile(pidfile, []byte("30\n"), 0o600))
+
+	infos := map[int32]processInfo{
+		10: {name: "postgres", cmdline: "/usr/bin/postgres -D /data", createTime: 100},
+		11: {name: "postgres", cmdline: "postgres: checkpointer", createTime: 200},
+		20: {name: "java", cmdline: "java -jar api.jar", createTime: 100},
+		30: {name: "nginx", cmdline: "nginx: master process", createTime: 100},
+	}
+	usages := map[int32]processUsage{
+		10: {rss: 1000, cpuTime: 10, fds: 5, threads: 1},
+		11: {rss: 500, cpuTime: 1, fds: 3, threads: 1},
+		20: {rss: 4000, cpuTime: 20, fds: 50, threads: 30},
+		30: {rss: 100, cpuTime: 1, fds: 10, threads: 1},
+	}
+	now := time.Now()
+
+	c, err := NewProcessCollector([]ProcessMatcher{
+		{Name: "postgres", Process: "postgres"},
+		{Name: "api", Cmdline: `java .*api\.jar`},
+		{Name: "nginx", Pidfile: pidfile},
+	})
+	require.NoError(t, err)
+	c.pids = func() ([]int32, error) {
+		var pids []int32
+		for pid := range infos {
+			pids = append(pids, pid)
+		}
+		return pids, nil
+	}
+	c.describe = func(pid int32) (processInfo, error) { return infos[pid], nil }
+	c.usage = func(pid int32) (processUsage, error) { return usages[pid], nil }
+	c.now = func() time.Time { return now }
+
+	memStorage := NewMemStorage()
+	require.NoError(t, c.Collect(&memStorage))
+
+	metrics := metricsByID(&memStorage)
+	assert.Equal(t, float64(2), *metrics["proc.postgres.count"].Value)
+	assert.Equal(t, float64(1500), *metrics["proc.postgres.rss"].Value)
+	assert.Equal(t, float64(8), *metrics["proc.postgres.open_fds"].Value)
+	assert.Equal(t, float64(30), *metrics["proc.api.threads"].Value)
+	assert.Equal(t, float64(100), *metrics["proc.nginx.rss"].Value)
+	assert.Equal(t, int64(0), *metrics["proc.nginx.restarts"].Delta)
+
+	t.Run("cpu percent from cpu time deltas", func(t *testing.T) {
+		now = now.Add(2 * time.Second)
+		usages[20] = processUsage{rss: 4000, cpuTime: 21, fds: 50, threads: 30}
+		require.NoError(t, c.Collect(&memStorage))
+
+		metrics = metricsByID(&memStorage)
+		assert.InDelta(t, 50, *metrics["proc.api.cpu_percent"].Value, 0.001)
+	})
+
+	t.Run("restart is counted when group leader changes", func(t *testing.T) {
+		delete(infos, 30)
+		infos[31] = processInfo{name: "nginx", createTime: 300}
+		usages[31] = usages[30]
+		require.NoError(t, os.WriteFile(pidfile, []byte("31"), 0o600))
+		now = now.Add(2 * time.Second)
+		require.NoError(t, c.Collect(&memStorage))
+
+		metrics = metricsByID(&memStorage)
+		assert.Equal(t, int64(1), *metrics["proc.nginx.restarts"].Delta)
+		assert.Equal(t, int64(0), *metrics["proc.postgres.restarts"].Delta)
+	})
+
+	t.Run("invalid matchers", func(t *testing.T) {
+		_, err := NewProcessCollector([]ProcessMatcher{{Name: "bad", Cmdline: "("}})
+		assert.Error(t, err)
+		_, err = NewProcessCollector([]ProcessMatcher{{Name: "empty"}})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+)
+
+// runtimeHistogramQuantiles are reported for every runtime/metrics histogram.
+var runtimeHistogramQuantiles = map[string]float64{"p50": 0.5, "p90": 0.9, "p99": 0.99}
+
+var runtimeMetricNameReplacer = strings.NewReplacer("/", ".", ":", "_", "-", "_")
+
+// RuntimeMetricsCollector reads every sample supported by runtime/metrics package.
+// Unlike runtime.ReadMemStats it doesn't stop the world.
+type RuntimeMetricsCollector struct {
+	compat  bool
+	samples []metrics.Sample
+}
+
+// NewRuntimeMetricsCollector returns RuntimeMetricsCollector object.
+// In compat mode legacy runtime.MemStats names, PollCount and RandomValue are also reported.
+func NewRuntimeMetricsCollector(compat bool) *RuntimeMetricsCollector {
+	descriptions := metrics.All()
+	samples := make([]metrics.Sample, len(descriptions))
+	for i, d := range descriptions {
+		samples[i].Name = d.Name
+	}
+
+	return &RuntimeMetricsCollector{compat: compat, samples: samples}
+}
+
+// Name returns collector name used in configuration.
+func (c *RuntimeMetricsCollector) Name() string {
+	return "runtime_metrics"
+}
+
+// Collect saves go.* gauges named after runtime/metrics keys, e.g. /sched/goroutines:goroutines
+// becomes go.sched.goroutines_goroutines. Histograms are reported as .p50, .p90, .p99 and .count gauges.
+func (c *RuntimeMetricsCollector) Collect(m *MemStorage) error {
+	metrics.Read(c.samples)
+
+	values := make(map[string]float64, len(c.samples))
+	for _, s := range c.samples {
+		name := runtimeMetricName(s.Name)
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			values[s.Name] = float64(s.Value.Uint64())
+			m.SetGauge(name, values[s.Name])
+		case metrics.KindFloat64:
+			values[s.Name] = s.Value.Float64()
+			m.SetGauge(name, values[s.Name])
+		case metrics.KindFloat64Histogram:
+			h := s.Value.Float64Histogram()
+			for suffix, q := range runtimeHistogramQuantiles {
+				m.SetGauge(name+"."+suffix, histogramQuantile(h, q))
+			}
+			m.SetGauge(name+".count", float64(histogramCount(h)))
+			values[s.Name] = histogramSum(h)
+		}
+	}
+
+	if c.compat {
+		c.collectLegacy(m, values)
+	}
+
+	return nil
+}
+
+// collectLegacy maps runtime/metrics values to runtime.MemStats fields as documented in runtime/metrics package.
+func (c *RuntimeMetricsCollector) collectLegacy(m *MemStorage, v map[string]float64) {
+	heapObjects := v["/memory/classes/heap/objects:bytes"]
+	heapUnused := v["/memory/classes/heap/unused:bytes"]
+	heapFree := v["/memory/classes/heap/free:bytes"]
+	heapReleased := v["/memory/classes/heap/released:bytes"]
+	stacks := v["/memory/classes/heap/stacks:bytes"]
+
+	gcPauses, ok := v["/sched/pauses/total/gc:seconds"]
+	if !ok {
+		gcPauses = v["/gc/pauses:seconds"]
+	}
+
+	var gcCPUFraction float64
+	if total := v["/cpu/classes/total:cpu-seconds"]; total > 0 {
+		gcCPUFraction = v["/cpu/classes/gc/total:cpu-seconds"] / total
+	}
+
+	var gcStats debug.GCStats
+	debug.ReadGCStats(&gcStats)
+
+	legacy := map[string]float64{
+		"Alloc":         heapObjects,
+		"BuckHashSys":   v["/memory/classes/profiling/buckets:bytes"],
+		"Frees":         v["/gc/heap/frees:objects"] + v["/gc/heap/tiny/allocs:objects"],
+		"GCCPUFraction": gcCPUFraction,
+		"GCSys":         v["/memory/classes/metadata/other:bytes"],
+		"HeapAlloc":     heapObjects,
+		"HeapIdle":      heapFree + heapReleased,
+		"HeapInuse":     heapObjects + heapUnused,
+		"HeapObjects":   v["/gc/heap/objects:objects"],
+		"HeapReleased":  heapReleased,
+		"HeapSys":       heapObjects + heapUnused + heapFree + heapReleased,
+		"LastGC":        float64(gcStats.LastGC.UnixNano()),
+		"Lookups":       0,
+		"MCacheInuse":   v["/memory/classes/metadata/mcache/inuse:bytes"],
+		"MCacheSys":     v["/memory/classes/metadata/mcache/inuse:bytes"] + v["/memory/classes/metadata/mcache/free:bytes"],
+		"MSpanInuse":    v["/memory/classes/metadata/mspan/inuse:bytes"],
+		"MSpanSys":      v["/memory/classes/metadata/mspan/inuse:bytes"] + v["/memory/classes/metadata/mspan/free:bytes"],
+		"Mallocs":       v["/gc/heap/allocs:objects"] + v["/gc/heap/tiny/allocs:objects"],
+		"NextGC":        v["/gc/heap/goal:bytes"],
+		"NumForcedGC":   v["/gc/cycles/forced:gc-cycles"],
+		"NumGC":         v["/gc/cycles/total:gc-cycles"],
+		"OtherSys":      v["/memory/classes/other:bytes"],
+		"PauseTotalNs":  gcPauses * 1e9,
+		"StackInuse":    stacks,
+		"StackSys":      stacks + v["/memory/classes/os-stacks:bytes"],
+		"Sys":           v["/memory/classes/total:bytes"],
+		"TotalAlloc":    v["/gc/heap/allocs:bytes"],
+		"RandomValue":   randFloat(0, 1000000),
+	}
+	if gcStats.LastGC.IsZero() {
+		legacy["LastGC"] = 0
+	}
+
+	m.mutex.Lock()
+	for name, value := range legacy {
+		m.gaugeMetrics[name] = value
+	}
+	m.counterMetrics["PollCount"]++
+	m.mutex.Unlock()
+}
+
+func runtimeMetricName(name string) string {
+	return "go." + runtimeMetricNameReplacer.Replace(strings.TrimPrefix(name, "/"))
+}
+
+func histogramCount(h *metrics.Float64Histogram) uint64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	return total
+}
+
+// histogramSum estimates sum of observed values by bucket lower boundaries.
+func histogramSum(h *metrics.Float64Histogram) float64 {
+	var sum float64
+	for i, c := range h.Counts {
+		if lower := h.Buckets[i]; c > 0 && !math.IsInf(lower, 0) {
+			sum += float64(c) * lower
+		}
+	}
+	return sum
+}
+
+// histogramQuantile returns upper boundary of the bucket where q-quantile falls.
+// Infinite boundary is replaced by the finite one of the same bucket.
+func histogramQuantile(h *metrics.Float64Histogram, q float64) float64 {
+	total := histogramCount(h)
+	if total == 0 {
+		return 0
+	}
+
+	target := q * float64(total)
+	var cumulative uint64
+	for i, c := range h.Counts {
+		cumulative += c
+		if float64(cumulative) < target || c == 0 {
+			continue
+		}
+		upper := h.Buckets[i+1]
+		if math.IsInf(upper, 1) {
+			return h.Buckets[i]
+		}
+		return upper
+	}
+
+	return h.Buckets[len(h.Buckets)-1]
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeMetricsCollector(t *testing.T) {
+	t.Run("reports runtime/metrics samples", func(t *testing.T) {
+		memStorage := NewMemStorage()
+		require.NoError(t, NewRuntimeMetricsCollector(false).Collect(&memStorage))
+
+		metrics := metricsByID(&memStorage)
+		assert.Contains(t, metrics, "go.sched.goroutines_goroutines")
+		assert.Contains(t, metrics, "go.sync.mutex.wait.total_seconds")
+		assert.Contains(t, metrics, "go.sched.latencies_seconds.p99")
+		assert.Contains(t, metrics, "go.gc.heap.allocs_bytes")
+		assert.NotContains(t, metrics, "HeapInuse")
+		assert.GreaterOrEqual(t, *metrics["go.sched.goroutines_goroutines"].Value, float64(1))
+	})
+
+	t.Run("compat mode keeps legacy names", func(t *testing.T) {
+		memStorage := NewMemStorage()
+		c := NewRuntimeMetricsCollector(true)
+		require.NoError(t, c.Collect(&memStorage))
+		require.NoError(t, c.Collect(&memStorage))
+
+		metrics := metricsByID(&memStorage)
+		for _, name := range []string{"Alloc", "HeapInuse", "HeapSys", "StackInuse", "MSpanSys", "NextGC", "TotalAlloc", "RandomValue", "LastGC"} {
+			assert.Contains(t, metrics, name)
+		}
+		assert.Greater(t, *metrics["HeapAlloc"].Value, float64(0))
+		assert.GreaterOrEqual(t, *metrics["HeapSys"].Value, *metrics["HeapInuse"].Value)
+		assert.Equal(t, int64(2), *metrics["PollCount"].Delta)
+	})
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 50, 40, 10},
+		Buckets: []float64{math.Inf(-1), 1, 2, 4, math.Inf(1)},
+	}
+
+	assert.Equal(t, float64(2), histogramQuantile(h, 0.5))
+	assert.Equal(t, float64(4), histogramQuantile(h, 0.9))
+	assert.Equal(t, float64(4), histogramQuantile(h, 0.99), "infinite upper boundary is replaced by lower one")
+	assert.Equal(t, uint64(100), histogramCount(h))
+	assert.Equal(t, 50*1+40*2+10*4.0, histogramSum(h))
+}
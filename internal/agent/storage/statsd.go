@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// statsdPacketSize is the biggest datagram read from socket.
+const statsdPacketSize = 65535
+
+type statsdSample struct {
+	name     string
+	kind     string
+	value    float64
+	rate     float64
+	relative bool
+}
+
+// StatsDCollector listens StatsD protocol on UDP and unix datagram sockets
+// and aggregates received metrics until the next poll.
+//
+// Counters ("|c") are scaled by sample rate and added to agent counters, so they are sent
+// as deltas and reset after successful sending like other counters. Gauges ("|g") keep the last
+// value, signed value ("+3", "-1") changes previous one. Timers ("|ms") are reported per poll
+// as <name>.count counter and <name>.{min,max,avg,p95} gauges.
+type StatsDCollector struct {
+	udpAddr  string
+	unixPath string
+
+	conns []net.PacketConn
+	wg    sync.WaitGroup
+
+	mutex    sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+	timers   map[string][]float64
+	invalid  int64
+}
+
+// NewStatsDCollector returns StatsDCollector object, empty address disables the socket.
+func NewStatsDCollector(udpAddr, unixPath string) *StatsDCollector {
+	return &StatsDCollector{
+		udpAddr:  udpAddr,
+		unixPath: unixPath,
+		counters: map[string]float64{},
+		gauges:   map[string]float64{},
+		timers:   map[string][]float64{},
+	}
+}
+
+// Name returns collector name used in configuration.
+func (c *StatsDCollector) Name() string {
+	return "statsd"
+}
+
+// Listen opens configured sockets and starts reading them.
+func (c *StatsDCollector) Listen() error {
+	if c.udpAddr != "" {
+		conn, err := net.ListenPacket("udp", c.udpAddr)
+		if err != nil {
+			return err
+		}
+		c.serve(conn)
+	}
+
+	if c.unixPath != "" {
+		// Socket file is left by previous run if agent wasn't stopped gracefully.
+		if err := os.Remove(c.unixPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			c.Close()
+			return err
+		}
+		conn, err := net.ListenPacket("unixgram", c.unixPath)
+		if err != nil {
+			c.Close()
+			return err
+		}
+		c.serve(conn)
+	}
+
+	return nil
+}
+
+// Close stops listening and waits for readers to finish.
+func (c *StatsDCollector) Close() error {
+	var errs []error
+	for _, conn := range c.conns {
+		errs = append(errs, conn.Close())
+	}
+	c.wg.Wait()
+	c.conns = nil
+
+	if c.unixPath != "" {
+		if err := os.Remove(c.unixPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *StatsDCollector) serve(conn net.PacketConn) {
+	c.conns = append(c.conns, conn)
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+		buf := make([]byte, statsdPacketSize)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			c.handlePacket(buf[:n])
+		}
+	}()
+}
+
+// handlePacket aggregates newline separated StatsD lines.
+func (c *StatsDCollector) handlePacket(packet []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		sample, err := parseStatsDLine(line)
+		if err != nil {
+			c.invalid++
+			continue
+		}
+
+		switch sample.kind {
+		case "c":
+			c.counters[sample.name] += sample.value / sample.rate
+		case "g":
+			if sample.relative {
+				c.gauges[sample.name] += sample.value
+			} else {
+				c.gauges[sample.name] = sample.value
+			}
+		case "ms":
+			c.timers[sample.name] = append(c.timers[sample.name], sample.value)
+		}
+	}
+}
+
+// Collect moves aggregated values into storage.
+// Fractional counter part left after sample rate scaling waits for the next poll.
+func (c *StatsDCollector) Collect(m *MemStorage) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for name, value := range c.counters {
+		whole := math.Trunc(value)
+		m.AddCounter(name, int64(whole))
+		c.counters[name] = value - whole
+	}
+
+	for name, value := range c.gauges {
+		m.SetGauge(name, value)
+	}
+
+	for name, values := range c.timers {
+		slices.Sort(values)
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+
+		m.AddCounter(name+".count", int64(len(values)))
+		m.SetGauge(name+".min", values[0])
+		m.SetGauge(name+".max", values[len(values)-1])
+		m.SetGauge(name+".avg", sum/float64(len(values)))
+		m.SetGauge(name+".p95", values[int(math.Ceil(0.95*float64(len(values))))-1])
+		delete(c.timers, name)
+	}
+
+	if c.invalid > 0 {
+		m.AddCounter("statsd.invalid_lines", c.invalid)
+		c.invalid = 0
+	}
+
+	return nil
+}
+
+// parseStatsDLine parses "name:value|type|@rate" line, DogStatsD tags ("|#tag") are ignored.
+func parseStatsDLine(line string) (statsdSample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return statsdSample{}, errors.New("no metric name")
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return statsdSample{}, errors.New("no metric type")
+	}
+
+	sample := statsdSample{name: name, kind: parts[1], rate: 1}
+	if sample.kind != "c" && sample.kind != "g" && sample.kind != "ms" {
+		return statsdSample{}, errors.New("unsupported metric type " + sample.kind)
+	}
+
+	rawValue := parts[0]
+	if sample.kind == "g" && (strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")) {
+		sample.relative = true
+	}
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return statsdSample{}, err
+	}
+	sample.value = value
+
+	for _, p := range parts[2:] {
+		rawRate, ok := strings.CutPrefix(p, "@")
+		if !ok {
+			continue
+		}
+		rate, err := strconv.ParseFloat(rawRate, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return statsdSample{}, errors.New("wrong sample rate " + rawRate)
+		}
+		sample.rate = rate
+	}
+
+	return sample, nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsDLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    statsdSample
+		wantErr bool
+	}{
+		{line: "requests:1|c", want: statsdSample{name: "requests", kind: "c", value: 1, rate: 1}},
+		{line: "requests:2|c|@0.5", want: statsdSample{name: "requests", kind: "c", value: 2, rate: 0.5}},
+		{line: "queue:-3|g", want: statsdSample{name: "queue", kind: "g", value: -3, rate: 1, relative: true}},
+		{line: "latency:12.5|ms|#env:prod", want: statsdSample{name: "latency", kind: "ms", value: 12.5, rate: 1}},
+		{line: "requests:1", wantErr: true},
+		{line: ":1|c", wantErr: true},
+		{line: "requests:x|c", wantErr: true},
+		{line: "requests:1|h", wantErr: true},
+		{line: "requests:1|c|@2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := parseStatsDLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStatsDCollector(t *testing.T) {
+	unixPath := filepath.Join(t.TempDir(), "statsd.sock")
+	c := NewStatsDCollector("127.0.0.1:0", unixPath)
+	require.NoError(t, c.Listen())
+	defer c.Close()
+
+	udpConn, err := net.Dial("udp", c.conns[0].LocalAddr().String())
+	require.NoError(t, err)
+	defer udpConn.Close()
+	unixConn, err := net.Dial("unixgram", unixPath)
+	require.NoError(t, err)
+	defer unixConn.Close()
+
+	memStorage := NewMemStorage()
+	_, err = udpConn.Write([]byte("requests:1|c\nrequests:1|c|@0.5\nqueue:10|g\nlatency:10|ms\nlatency:20|ms\nlatency:30|ms\nbroken"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		require.NoError(t, c.Collect(&memStorage))
+		_, ok := metricsByID(&memStorage)["queue"]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	metrics := metricsByID(&memStorage)
+	assert.Equal(t, int64(3), *metrics["latency.count"].Delta)
+	assert.Equal(t, float64(10), *metrics["latency.min"].Value)
+	assert.Equal(t, float64(30), *metrics["latency.max"].Value)
+	assert.Equal(t, float64(20), *metrics["latency.avg"].Value)
+	assert.Equal(t, float64(30), *metrics["latency.p95"].Value)
+
+	_, err = unixConn.Write([]byte("queue:-4|g\nrequests:1|c"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		require.NoError(t, c.Collect(&memStorage))
+		return *metricsByID(&memStorage)["queue"].Value == 6
+	}, time.Second, 10*time.Millisecond)
+
+	metrics = metricsByID(&memStorage)
+	assert.Equal(t, int64(4), *metrics["requests"].Delta)
+	assert.Equal(t, int64(1), *metrics["statsd.invalid_lines"].Delta)
+
+	t.Run("counters are sent once", func(t *testing.T) {
+		memStorage.AckCounters(memStorage.GetAllMetrics())
+		require.NoError(t, c.Collect(&memStorage))
+
+		metrics = metricsByID(&memStorage)
+		assert.Equal(t, int64(0), *metrics["requests"].Delta)
+		assert.Equal(t, float64(6), *metrics["queue"].Value, "gauge keeps the last value")
+	})
+}
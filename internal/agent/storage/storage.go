@@ -2,12 +2,12 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"runtime"
 	"sync"
 
-	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/shirou/gopsutil/v4/mem"
 
 	"github.com/aykuli/observer/internal/models"
@@ -70,40 +70,40 @@ func (m *MemStorage) GarbageStats() {
 	m.mutex.Unlock()
 }
 
-// GetSystemUtilInfo gets virtual memory metrics values.
-func (m *MemStorage) GetSystemUtilInfo() {
+func (m *MemStorage) collectVirtualMemory() error {
 	vm, err := mem.VirtualMemory()
 	if err != nil {
-		return
+		return err
 	}
+
 	m.mutex.Lock()
 	m.gaugeMetrics["TotalMemory"] = float64(vm.Total)
 	m.gaugeMetrics["FreeMemory"] = float64(vm.Free)
 	m.mutex.Unlock()
 
-	cpuCount, err := cpu.Counts(false)
-	if err != nil {
-		return
-	}
-	vmPercent, err := cpu.Percent(0, true)
-	if err != nil {
-		return
-	}
+	return nil
+}
 
+// SetGauge saves gauge metric value, previous value is replaced.
+func (m *MemStorage) SetGauge(name string, value float64) {
 	m.mutex.Lock()
-	for i := 0; i < cpuCount; i++ {
-		mName := fmt.Sprintf("CPUutilization%d", i)
-		m.gaugeMetrics[mName] = vmPercent[i]
-	}
+	m.gaugeMetrics[name] = value
+	m.mutex.Unlock()
+}
+
+// AddCounter adds delta to counter metric value.
+func (m *MemStorage) AddCounter(name string, delta int64) {
+	m.mutex.Lock()
+	m.counterMetrics[name] += delta
 	m.mutex.Unlock()
 }
 
-// GetAllMetrics returns array of metrics.
+// GetAllMetrics returns array of metrics. Counter deltas are values pending since the last acknowledgement.
 func (m *MemStorage) GetAllMetrics() []models.Metric {
+	m.mutex.RLock()
 	var outMetrics = make([]models.Metric, len(m.gaugeMetrics)+len(m.counterMetrics))
 
 	i := 0
-	m.mutex.RLock()
 	for k := range m.gaugeMetrics {
 		v := m.gaugeMetrics[k]
 		outMetrics[i] = models.Metric{ID: k, MType: "gauge", Delta: nil, Value: &v}
@@ -119,11 +119,47 @@ func (m *MemStorage) GetAllMetrics() []models.Metric {
 	return outMetrics
 }
 
-// ResetCounter sets counter PollCount to zero value as the flag
-// that metrics got from previous circle of grabbing sent to storage server successfully.
-func (m *MemStorage) ResetCounter() {
+// SaveMetrics merges metrics in server format into storage. Gauge values replace
+// previous ones, counter deltas are added. Invalid metrics are not saved at all.
+// Metrics are kept by ID, so labelled metrics are rejected, agent attaches its own labels on sending.
+func (m *MemStorage) SaveMetrics(metrics []models.Metric) error {
+	for _, mt := range metrics {
+		if mt.ID == "" {
+			return errors.New("metric id is empty")
+		}
+		if len(mt.Labels) > 0 {
+			return fmt.Errorf("metric %s has labels, agent doesn't keep labels of metrics", mt.ID)
+		}
+		switch {
+		case mt.MType == "gauge" && mt.Value != nil:
+		case mt.MType == "counter" && mt.Delta != nil:
+		default:
+			return fmt.Errorf("metric %s has wrong type or value", mt.ID)
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, mt := range metrics {
+		if mt.MType == "gauge" {
+			m.gaugeMetrics[mt.ID] = *mt.Value
+		} else {
+			m.counterMetrics[mt.ID] += *mt.Delta
+		}
+	}
+
+	return nil
+}
+
+// AckCounters subtracts counter deltas confirmed by storage server from pending values.
+// Increments made while metrics were being sent stay pending till the next sending.
+func (m *MemStorage) AckCounters(metrics []models.Metric) {
 	m.mutex.Lock()
-	m.counterMetrics["PollCount"] = 0
+	for _, mt := range metrics {
+		if mt.MType == "counter" && mt.Delta != nil {
+			m.counterMetrics[mt.ID] -= *mt.Delta
+		}
+	}
 	m.mutex.Unlock()
 }
 
@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGarbageStats(t *testing.T) {
@@ -23,17 +26,61 @@ func TestGarbageStats(t *testing.T) {
 		assert.Contains(t, metricNames, "StackInuse")
 	})
 
-	t.Run("get system util info values", func(t *testing.T) {
+}
+
+func TestAckCounters(t *testing.T) {
+	t.Run("increments made while sending stay pending", func(t *testing.T) {
 		memStorage := NewMemStorage()
-		memStorage.GetSystemUtilInfo()
+		memStorage.AddCounter("hits", 5)
+		memStorage.SetGauge("temp", 1.5)
+
+		sent := memStorage.GetAllMetrics()
+		memStorage.AddCounter("hits", 3)
+		memStorage.AckCounters(sent)
 
-		var metricNames []string
 		for _, mt := range memStorage.GetAllMetrics() {
-			metricNames = append(metricNames, mt.ID)
+			if mt.ID == "hits" {
+				assert.Equal(t, int64(3), *mt.Delta)
+			}
+			if mt.ID == "temp" {
+				assert.Equal(t, 1.5, *mt.Value)
+			}
+		}
+	})
+
+	t.Run("concurrent acknowledgement doesn't lose increments", func(t *testing.T) {
+		memStorage := NewMemStorage()
+		const writers, increments = 8, 1000
+
+		var acked atomic.Int64
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for acked.Load() < writers*increments {
+				sent := memStorage.GetAllMetrics()
+				for _, mt := range sent {
+					acked.Add(*mt.Delta)
+				}
+				memStorage.AckCounters(sent)
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < increments; i++ {
+					memStorage.AddCounter("hits", 1)
+				}
+			}()
 		}
+		wg.Wait()
+		<-done
 
-		assert.Contains(t, metricNames, "TotalMemory")
-		assert.Contains(t, metricNames, "FreeMemory")
-		assert.Contains(t, metricNames, "CPUutilization1")
+		assert.Equal(t, int64(writers*increments), acked.Load())
+		metrics := memStorage.GetAllMetrics()
+		require.Len(t, metrics, 1)
+		assert.Equal(t, int64(0), *metrics[0].Delta)
 	})
 }
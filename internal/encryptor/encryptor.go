@@ -0,0 +1,218 @@
+// Package encryptor provides hybrid encryption of request bodies: body is sealed with random AES-256-GCM key,
+// the key is wrapped with RSA-OAEP server public key, so only server owning the private key can read metrics.
+package encryptor
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// HeaderName is request header marking encrypted body, HeaderValue names the encryption scheme.
+const (
+	HeaderName  = "Encryption"
+	HeaderValue = "RSA-OAEP-AES256-GCM"
+)
+
+const aesKeySize = 32
+
+var errWrongPayload = errors.New("encrypted payload is too short")
+
+// LoadPublicKey reads PEM encoded RSA public key in PKIX or PKCS #1 form.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not RSA public key", path)
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+	}
+}
+
+// LoadPrivateKey reads PEM encoded RSA private key in PKCS #1 or PKCS #8 form.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not RSA private key", path)
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	return block, nil
+}
+
+// Encrypt seals data with random AES-GCM key. Returned payload consists of the key wrapped with RSA-OAEP,
+// GCM nonce and sealed data.
+func Encrypt(publicKey *rsa.PublicKey, data []byte) ([]byte, error) {
+	key := make([]byte, aesKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, 0, len(wrappedKey)+len(nonce)+len(data)+gcm.Overhead())
+	payload = append(payload, wrappedKey...)
+	payload = append(payload, nonce...)
+
+	return gcm.Seal(payload, nonce, data, nil), nil
+}
+
+// Decrypt opens payload made by Encrypt.
+func Decrypt(privateKey *rsa.PrivateKey, payload []byte) ([]byte, error) {
+	keySize := privateKey.Size()
+	if len(payload) < keySize {
+		return nil, errWrongPayload
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), nil, privateKey, payload[:keySize], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	payload = payload[keySize:]
+	if len(payload) < gcm.NonceSize() {
+		return nil, errWrongPayload
+	}
+
+	return gcm.Open(nil, payload[:gcm.NonceSize()], payload[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// DecryptMiddleware replaces encrypted request body with decrypted one. Encryption header is kept,
+// so RequireEncryption placed after it can tell the body was decrypted.
+// Requests without Encryption header are passed as they are. If private key is nil, middleware does nothing.
+func DecryptMiddleware(privateKey *rsa.PrivateKey) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if privateKey == nil {
+			return h
+		}
+
+		decryptFn := func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(HeaderName) == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if r.Header.Get(HeaderName) != HeaderValue {
+				http.Error(w, "unsupported encryption", http.StatusBadRequest)
+				return
+			}
+
+			payload, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "cannot read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
+
+			body, err := Decrypt(privateKey, payload)
+			if err != nil {
+				http.Error(w, "cannot decrypt request body", http.StatusBadRequest)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			h.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(decryptFn)
+	}
+}
+
+// RequireEncryption rejects requests which weren't encrypted, it should be placed after DecryptMiddleware.
+// If private key is nil, middleware does nothing.
+func RequireEncryption(privateKey *rsa.PrivateKey) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if privateKey == nil {
+			return h
+		}
+
+		requireFn := func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(HeaderName) != HeaderValue {
+				http.Error(w, "request body should be encrypted", http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(requireFn)
+	}
+}
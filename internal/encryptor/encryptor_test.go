@@ -0,0 +1,183 @@
+package encryptor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aykuli/observer/internal/compressor"
+	"github.com/aykuli/observer/internal/models"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func writePEM(t *testing.T, blockType string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600))
+	return path
+}
+
+func TestLoadKeys(t *testing.T) {
+	key := generateKey(t)
+
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	t.Run("public key formats", func(t *testing.T) {
+		pub, err := LoadPublicKey(writePEM(t, "PUBLIC KEY", pkix))
+		require.NoError(t, err)
+		assert.True(t, key.PublicKey.Equal(pub))
+
+		pub, err = LoadPublicKey(writePEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey)))
+		require.NoError(t, err)
+		assert.True(t, key.PublicKey.Equal(pub))
+	})
+
+	t.Run("private key formats", func(t *testing.T) {
+		priv, err := LoadPrivateKey(writePEM(t, "PRIVATE KEY", pkcs8))
+		require.NoError(t, err)
+		assert.True(t, key.Equal(priv))
+
+		priv, err = LoadPrivateKey(writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)))
+		require.NoError(t, err)
+		assert.True(t, key.Equal(priv))
+	})
+
+	t.Run("wrong files", func(t *testing.T) {
+		_, err := LoadPublicKey(filepath.Join(t.TempDir(), "missing.pem"))
+		assert.Error(t, err)
+
+		_, err = LoadPrivateKey(writePEM(t, "PUBLIC KEY", pkix))
+		assert.Error(t, err)
+	})
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := generateKey(t)
+	data := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+
+	payload, err := Encrypt(&key.PublicKey, data)
+	require.NoError(t, err)
+	assert.NotContains(t, string(payload), "PollCount")
+
+	decrypted, err := Decrypt(key, payload)
+	require.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+
+	t.Run("tampered payload is rejected", func(t *testing.T) {
+		tampered := bytes.Clone(payload)
+		tampered[len(tampered)-1] ^= 0xff
+		_, err := Decrypt(key, tampered)
+		assert.Error(t, err)
+	})
+
+	t.Run("other key can't decrypt", func(t *testing.T) {
+		_, err := Decrypt(generateKey(t), payload)
+		assert.Error(t, err)
+	})
+
+	t.Run("short payload", func(t *testing.T) {
+		_, err := Decrypt(key, payload[:10])
+		assert.Error(t, err)
+	})
+}
+
+func TestDecryptMiddleware(t *testing.T) {
+	key := generateKey(t)
+
+	var got models.Metric
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+	ts := httptest.NewServer(DecryptMiddleware(key)(compressor.GzipMiddleware(handler)))
+	defer ts.Close()
+
+	value := 1.5
+	body, err := json.Marshal(models.Metric{ID: "temp", MType: "gauge", Value: &value})
+	require.NoError(t, err)
+	gzipped, err := compressor.Compress(body)
+	require.NoError(t, err)
+
+	post := func(payload []byte, encryption string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(payload))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		if encryption != "" {
+			req.Header.Set(HeaderName, encryption)
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		_, err = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	t.Run("encrypted gzipped body", func(t *testing.T) {
+		payload, err := Encrypt(&key.PublicKey, gzipped)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, post(payload, HeaderValue))
+		assert.Equal(t, "temp", got.ID)
+	})
+
+	t.Run("not encrypted body is passed as is", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, post(gzipped, ""))
+	})
+
+	t.Run("broken payload", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(gzipped, HeaderValue))
+	})
+
+	t.Run("unknown scheme", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(gzipped, "ROT13"))
+	})
+}
+
+func TestRequireEncryption(t *testing.T) {
+	key := generateKey(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	ts := httptest.NewServer(DecryptMiddleware(key)(RequireEncryption(key)(handler)))
+	defer ts.Close()
+
+	post := func(payload []byte, encryption string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(payload))
+		require.NoError(t, err)
+		if encryption != "" {
+			req.Header.Set(HeaderName, encryption)
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	payload, err := Encrypt(&key.PublicKey, []byte(`{"id":"temp"}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, post(payload, HeaderValue))
+	assert.Equal(t, http.StatusBadRequest, post([]byte(`{"id":"temp"}`), ""), "not encrypted body")
+
+	assert.NotNil(t, RequireEncryption(nil)(handler))
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateLabels checks label names look like identifiers and values are non-empty
+// and don't contain characters used by labels string: ",", "=", "{" and "}".
+func ValidateLabels(labels map[string]string) error {
+	for name, value := range labels {
+		if !labelNameRe.MatchString(name) {
+			return fmt.Errorf("wrong label name %q", name)
+		}
+		if value == "" || strings.ContainsAny(value, ",={}") {
+			return fmt.Errorf("wrong value %q of label %s", value, name)
+		}
+	}
+
+	return nil
+}
+
+// ValidateSeries checks metric ID doesn't contain characters of labels string, so series name of
+// one metric can't be taken for another's, and validates labels.
+func ValidateSeries(id string, labels map[string]string) error {
+	if strings.ContainsAny(id, ",={}") {
+		return fmt.Errorf("wrong metric name %q", id)
+	}
+	return ValidateLabels(labels)
+}
+
+// LabelsString returns labels sorted by name like "host=a,service=b". It's empty if there are no labels.
+func LabelsString(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// ParseLabels parses labels string like "host=a,service=b". Empty string means no labels.
+func ParseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("label %q should look like name=value", pair)
+		}
+		if _, ok = labels[name]; ok {
+			return nil, fmt.Errorf("label %s is repeated", name)
+		}
+		labels[name] = value
+	}
+
+	if err := ValidateLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// SeriesName returns metric name with labels like "HeapAlloc{host=a}" identifying metric among others of its type.
+// Metric without labels is identified by its name only.
+func SeriesName(id string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return id
+	}
+	return id + "{" + LabelsString(labels) + "}"
+}
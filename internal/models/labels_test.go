@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabels(t *testing.T) {
+	labels := map[string]string{"service": "api", "host": "node-1.example.com"}
+	require.NoError(t, ValidateLabels(labels))
+
+	s := LabelsString(labels)
+	assert.Equal(t, "host=node-1.example.com,service=api", s)
+	assert.Equal(t, "HeapAlloc{host=node-1.example.com,service=api}", SeriesName("HeapAlloc", labels))
+	assert.Equal(t, "HeapAlloc", SeriesName("HeapAlloc", nil))
+	assert.Empty(t, LabelsString(map[string]string{}))
+
+	parsed, err := ParseLabels(s)
+	require.NoError(t, err)
+	assert.Equal(t, labels, parsed)
+
+	parsed, err = ParseLabels("")
+	require.NoError(t, err)
+	assert.Nil(t, parsed)
+
+	for _, wrong := range []string{"host", "host=", "1host=a", "host=a,host=b", "host=a{b}", "host=a=b"} {
+		_, err = ParseLabels(wrong)
+		assert.Error(t, err, wrong)
+	}
+
+	require.NoError(t, ValidateSeries("HeapAlloc", labels))
+	for _, wrong := range []string{"HeapAlloc{host=a}", "HeapAlloc,host", "host=a"} {
+		assert.Error(t, ValidateSeries(wrong, nil), wrong)
+	}
+}
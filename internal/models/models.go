@@ -1,10 +1,12 @@
 // Package models keeps Metric struct.
 package models
 
-// Metric struct provides  json tagged metrics fields
+// Metric struct provides  json tagged metrics fields.
+// Metric is identified by name, type and labels, so agents may send metrics with the same name.
 type Metric struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Delta  *int64            `json:"delta,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
 }
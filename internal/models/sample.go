@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Sample struct keeps metric value recorded at some moment. Gauge samples keep the written value,
+// counter samples keep the counter total after the delta was added.
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
@@ -0,0 +1,68 @@
+// Package proto keeps gRPC Metrics service generated from metrics.proto
+// and conversion of its messages to models.Metric.
+package proto
+
+import (
+	"fmt"
+
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/aykuli/observer/internal/models"
+)
+
+// SignMetadataKey is gRPC metadata key of HMAC SHA256 request and response signature.
+const SignMetadataKey = "hashsha256"
+
+// SignedBytes returns deterministic message encoding the signature is calculated of.
+func SignedBytes(msg protobuf.Message) ([]byte, error) {
+	return protobuf.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// FromModel converts metric in server format to protobuf message.
+func FromModel(metric models.Metric) (*Metric, error) {
+	out := &Metric{Id: metric.ID, Labels: metric.Labels}
+	switch {
+	case metric.MType == "gauge" && metric.Value != nil:
+		out.Type = Metric_GAUGE
+		out.Value = *metric.Value
+	case metric.MType == "counter" && metric.Delta != nil:
+		out.Type = Metric_COUNTER
+		out.Delta = *metric.Delta
+	default:
+		return nil, fmt.Errorf("metric %s has wrong type or value", metric.ID)
+	}
+
+	return out, nil
+}
+
+// ToModel converts protobuf message to metric in server format.
+func ToModel(metric *Metric) (models.Metric, error) {
+	out := models.Metric{ID: metric.GetId(), MType: TypeName(metric.GetType())}
+	if len(metric.GetLabels()) > 0 {
+		out.Labels = metric.GetLabels()
+	}
+	switch metric.GetType() {
+	case Metric_GAUGE:
+		value := metric.GetValue()
+		out.Value = &value
+	case Metric_COUNTER:
+		delta := metric.GetDelta()
+		out.Delta = &delta
+	default:
+		return out, fmt.Errorf("metric %s has unknown type", metric.GetId())
+	}
+
+	return out, nil
+}
+
+// TypeName returns metric type name used by HTTP API and storage.
+func TypeName(t Metric_MType) string {
+	switch t {
+	case Metric_GAUGE:
+		return "gauge"
+	case Metric_COUNTER:
+		return "counter"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,173 @@
+// Package agents provides registry of agents sending metrics to server.
+package agents
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/subnet"
+	"github.com/aykuli/observer/internal/sign"
+)
+
+// Agent identity headers covered by request signature. gRPC agents send them in metadata with lowercase keys.
+const (
+	IDHeader             = sign.AgentIDHeader
+	VersionHeader        = sign.AgentVersionHeader
+	ReportIntervalHeader = sign.ReportIntervalHeader
+)
+
+// DefaultReportInterval is used for agents not sending their report interval, it is agent default.
+const DefaultReportInterval = 10 * time.Second
+
+// Registry limits. Agent is forgotten when it has been stale forgetFactor times longer than its stale period,
+// and the least recently seen agent is forgotten when registry is full.
+const (
+	MaxAgents    = 10000
+	forgetFactor = 10
+)
+
+// Info struct describes agent sending the request.
+type Info struct {
+	ID             string
+	Version        string
+	IP             string
+	ReportInterval time.Duration
+}
+
+// InfoFrom reads agent info from request headers or gRPC metadata provided by lookup function.
+// IP is taken from X-Real-IP value or remote address. False is returned if agent ID isn't sent.
+func InfoFrom(lookup func(key string) string, remoteAddr string) (Info, bool) {
+	info := Info{
+		ID:             lookup(IDHeader),
+		Version:        lookup(VersionHeader),
+		IP:             lookup(subnet.RealIPHeader),
+		ReportInterval: DefaultReportInterval,
+	}
+	if info.ID == "" {
+		return info, false
+	}
+
+	if info.IP == "" {
+		info.IP = remoteAddr
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			info.IP = host
+		}
+	}
+	if seconds, err := strconv.Atoi(lookup(ReportIntervalHeader)); err == nil && seconds > 0 {
+		info.ReportInterval = time.Duration(seconds) * time.Second
+	}
+
+	return info, true
+}
+
+// Agent struct is registry record. Metrics is quantity of distinct metrics the agent has sent,
+// report interval is in seconds. Agent is stale if it has missed configured number of report intervals.
+type Agent struct {
+	ID             string    `json:"id"`
+	Version        string    `json:"version,omitempty"`
+	IP             string    `json:"ip,omitempty"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	ReportInterval int       `json:"report_interval"`
+	Metrics        int       `json:"metrics"`
+	Stale          bool      `json:"stale"`
+}
+
+type record struct {
+	Agent
+	interval time.Duration
+	series   map[string]struct{}
+}
+
+// Registry struct keeps agents seen since server start.
+type Registry struct {
+	mutex          sync.Mutex
+	agents         map[string]*record
+	staleIntervals int
+	maxAgents      int
+	now            func() time.Time
+}
+
+// NewRegistry creates registry marking agents stale after staleIntervals missed report intervals.
+// Registry keeps up to MaxAgents agents.
+func NewRegistry(staleIntervals int) *Registry {
+	return &Registry{
+		agents:         make(map[string]*record),
+		staleIntervals: staleIntervals,
+		maxAgents:      MaxAgents,
+		now:            time.Now,
+	}
+}
+
+// Seen records agent request with metrics it has sent. Nil registry does nothing.
+func (r *Registry) Seen(info Info, metrics []models.Metric) {
+	if r == nil {
+		return
+	}
+
+	now := r.now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rec, ok := r.agents[info.ID]
+	if !ok {
+		r.forget(now, true)
+		rec = &record{Agent: Agent{ID: info.ID, FirstSeen: now}, series: make(map[string]struct{})}
+		r.agents[info.ID] = rec
+	}
+	rec.Version = info.Version
+	rec.IP = info.IP
+	rec.LastSeen = now
+	rec.interval = info.ReportInterval
+	for _, m := range metrics {
+		rec.series[m.MType+" "+models.SeriesName(m.ID, m.Labels)] = struct{}{}
+	}
+}
+
+// List returns agents sorted by ID. Nil registry returns empty list.
+func (r *Registry) List() []Agent {
+	list := []Agent{}
+	if r == nil {
+		return list
+	}
+
+	now := r.now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.forget(now, false)
+	for _, rec := range r.agents {
+		agent := rec.Agent
+		agent.ReportInterval = int(rec.interval / time.Second)
+		agent.Metrics = len(rec.series)
+		agent.Stale = now.Sub(rec.LastSeen) > time.Duration(r.staleIntervals)*rec.interval
+		list = append(list, agent)
+	}
+	slices.SortFunc(list, func(a, b Agent) int { return cmp.Compare(a.ID, b.ID) })
+
+	return list
+}
+
+// forget drops agents stale for too long. If registry is full yet, the least recently seen agent is dropped
+// to make room for a new one.
+func (r *Registry) forget(now time.Time, makeRoom bool) {
+	var oldest *record
+	for id, rec := range r.agents {
+		if now.Sub(rec.LastSeen) > time.Duration(forgetFactor*r.staleIntervals)*rec.interval {
+			delete(r.agents, id)
+			continue
+		}
+		if oldest == nil || rec.LastSeen.Before(oldest.LastSeen) {
+			oldest = rec
+		}
+	}
+
+	if makeRoom && oldest != nil && len(r.agents) >= r.maxAgents {
+		delete(r.agents, oldest.ID)
+	}
+}
@@ -0,0 +1,97 @@
+package agents
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aykuli/observer/internal/models"
+)
+
+func TestInfoFrom(t *testing.T) {
+	header := http.Header{}
+	_, ok := InfoFrom(header.Get, "10.0.0.1:5000")
+	assert.False(t, ok, "request without agent ID")
+
+	header.Set(IDHeader, "web-1")
+	info, ok := InfoFrom(header.Get, "10.0.0.1:5000")
+	require.True(t, ok)
+	assert.Equal(t, Info{ID: "web-1", IP: "10.0.0.1", ReportInterval: DefaultReportInterval}, info)
+
+	header.Set(VersionHeader, "v1.2")
+	header.Set(ReportIntervalHeader, "30")
+	header.Set("X-Real-IP", "192.168.1.10")
+	info, ok = InfoFrom(header.Get, "10.0.0.1:5000")
+	require.True(t, ok)
+	assert.Equal(t, Info{ID: "web-1", Version: "v1.2", IP: "192.168.1.10", ReportInterval: 30 * time.Second}, info)
+}
+
+func TestRegistry(t *testing.T) {
+	start := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	now := start
+	r := NewRegistry(3)
+	r.now = func() time.Time { return now }
+
+	value, delta := 1.0, int64(1)
+	r.Seen(Info{ID: "web-2", Version: "v1.0", IP: "10.0.0.2", ReportInterval: time.Minute}, []models.Metric{
+		{ID: "cpu", MType: "gauge", Value: &value},
+	})
+	r.Seen(Info{ID: "web-1", Version: "v1.0", IP: "10.0.0.1", ReportInterval: 10 * time.Second}, []models.Metric{
+		{ID: "cpu", MType: "gauge", Value: &value},
+		{ID: "hits", MType: "counter", Delta: &delta},
+	})
+	now = start.Add(20 * time.Second)
+	r.Seen(Info{ID: "web-1", Version: "v1.1", IP: "10.0.0.3", ReportInterval: 10 * time.Second}, []models.Metric{
+		{ID: "hits", MType: "counter", Delta: &delta},
+		{ID: "hits", MType: "counter", Delta: &delta, Labels: map[string]string{"path": "/"}},
+	})
+
+	now = start.Add(time.Minute)
+	assert.Equal(t, []Agent{
+		{
+			ID: "web-1", Version: "v1.1", IP: "10.0.0.3", FirstSeen: start, LastSeen: start.Add(20 * time.Second),
+			ReportInterval: 10, Metrics: 3, Stale: true,
+		},
+		{
+			ID: "web-2", Version: "v1.0", IP: "10.0.0.2", FirstSeen: start, LastSeen: start,
+			ReportInterval: 60, Metrics: 1,
+		},
+	}, r.List())
+
+	var nilRegistry *Registry
+	nilRegistry.Seen(Info{ID: "web-1"}, nil)
+	assert.Empty(t, nilRegistry.List())
+}
+
+func TestRegistryForgetsAgents(t *testing.T) {
+	start := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	now := start
+	r := NewRegistry(3)
+	r.now = func() time.Time { return now }
+	r.maxAgents = 2
+
+	ids := func() []string {
+		var list []string
+		for _, agent := range r.List() {
+			list = append(list, agent.ID)
+		}
+		return list
+	}
+
+	r.Seen(Info{ID: "web-1", ReportInterval: 10 * time.Second}, nil)
+	now = now.Add(time.Second)
+	r.Seen(Info{ID: "web-2", ReportInterval: 10 * time.Second}, nil)
+	now = now.Add(time.Second)
+	r.Seen(Info{ID: "web-1", ReportInterval: 10 * time.Second}, nil)
+	now = now.Add(time.Second)
+	r.Seen(Info{ID: "web-3", ReportInterval: 10 * time.Second}, nil)
+	assert.Equal(t, []string{"web-1", "web-3"}, ids(), "least recently seen agent makes room for new one")
+
+	now = start.Add(5 * time.Minute)
+	assert.Equal(t, []string{"web-1", "web-3"}, ids(), "stale agents are kept for a while")
+	now = start.Add(5*time.Minute + 4*time.Second)
+	assert.Empty(t, ids(), "agents stale for 10 stale periods are forgotten")
+}
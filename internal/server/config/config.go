@@ -9,7 +9,21 @@ type Config struct {
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
 	Restore         bool   `env:"RESTORE"`
 	DatabaseDsn     string `env:"DATABASE_DSN"`
+	HistorySize     int    `env:"HISTORY_SIZE"`
+	Retention       string `env:"RETENTION"`
+	CompactInterval int    `env:"COMPACT_INTERVAL"`
 	Key             string `env:"KEY"`
+	SignSkew        int    `env:"SIGN_SKEW"`
+	SignLegacy      bool   `env:"SIGN_LEGACY"`
+	CryptoKey       string `env:"CRYPTO_KEY"`
+	TLSCert         string `env:"TLS_CERT"`
+	TLSKey          string `env:"TLS_KEY"`
+	TLSClientCA     string `env:"TLS_CLIENT_CA"`
+	GRPCAddress     string `env:"GRPC_ADDRESS"`
+	AgentStale      int    `env:"AGENT_STALE_INTERVALS"`
+
+	TrustedSubnet           string `env:"TRUSTED_SUBNET"`
+	TrustedSubnetRemoteAddr bool   `env:"TRUSTED_SUBNET_REMOTE_ADDR"`
 }
 
 // Configuration default constants
@@ -18,6 +32,11 @@ const (
 	hostDefault          = "localhost"
 	portDefault          = "8080"
 	fileStorageDefault   = "/tmp/metrics-db.json"
+	signSkewDefault      = 300
+	historySizeDefault   = 0
+	retentionDefault     = "raw:48h,1m:30d,1h:365d"
+	compactDefault       = 600
+	agentStaleDefault    = 3
 )
 
 var Options = Config{
@@ -26,6 +45,11 @@ var Options = Config{
 	FileStoragePath: fileStorageDefault,
 	Restore:         true,
 	DatabaseDsn:     "",
+	SignSkew:        signSkewDefault,
+	HistorySize:     historySizeDefault,
+	Retention:       retentionDefault,
+	CompactInterval: compactDefault,
+	AgentStale:      agentStaleDefault,
 }
 
 func init() {
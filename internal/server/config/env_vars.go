@@ -15,4 +15,7 @@ func parseEnvVars() {
 	if Options.StoreInterval < 0 {
 		Options.StoreInterval = storeIntervalDefault
 	}
+	if Options.AgentStale <= 0 {
+		Options.AgentStale = agentStaleDefault
+	}
 }
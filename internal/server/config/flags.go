@@ -12,7 +12,20 @@ func parseFlags(args []string) {
 	fs.IntVar(&Options.StoreInterval, "i", 300, "metrics store interval in seconds")
 	fs.BoolVar(&Options.Restore, "r", true, "restore metrics from file")
 	fs.StringVar(&Options.DatabaseDsn, "d", "", "database source name")
+	fs.StringVar(&Options.Retention, "retention", retentionDefault, "samples retention tiers resolution:retention, e.g. raw:48h,1m:30d,1h:365d, or none")
+	fs.IntVar(&Options.CompactInterval, "compact-interval", compactDefault, "samples compaction interval in seconds")
+	fs.IntVar(&Options.HistorySize, "history-size", historySizeDefault, "samples of every metric kept in memory when database isn't used, by default they are kept till retention policy removes them or 10000 without policy")
 	fs.StringVar(&Options.Key, "k", "", "secret key to sign response")
+	fs.IntVar(&Options.SignSkew, "sign-skew", signSkewDefault, "allowed difference in seconds between signed request timestamp and server time")
+	fs.BoolVar(&Options.SignLegacy, "sign-legacy", false, "accept requests signed with HashSHA256 header over body only, without timestamp and nonce, they can be replayed")
+	fs.IntVar(&Options.AgentStale, "agent-stale-intervals", agentStaleDefault, "agent is marked stale after this number of missed report intervals")
+	fs.StringVar(&Options.CryptoKey, "crypto-key", "", "path to PEM file with RSA private key to decrypt agent requests")
+	fs.StringVar(&Options.TrustedSubnet, "t", "", "trusted subnet in CIDR notation, updates from other agents are forbidden")
+	fs.BoolVar(&Options.TrustedSubnetRemoteAddr, "trusted-subnet-remote-addr", false, "check connection remote address against trusted subnet instead of X-Real-IP header")
+	fs.StringVar(&Options.TLSCert, "tls-cert", "", "path to PEM file with server TLS certificate, enables HTTPS")
+	fs.StringVar(&Options.TLSKey, "tls-key", "", "path to PEM file with server TLS private key")
+	fs.StringVar(&Options.GRPCAddress, "grpc-address", "", "address to run gRPC metrics service on, e.g. localhost:3200")
+	fs.StringVar(&Options.TLSClientCA, "tls-client-ca", "", "path to PEM file with CA certificates, agents must present client certificates signed by them")
 
 	err := fs.Parse(args)
 	if err != nil {
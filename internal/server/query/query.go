@@ -0,0 +1,216 @@
+// Package query provides range queries over metric samples kept by storage.
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/storage"
+)
+
+// Aggregation functions applied to samples of every bucket.
+const (
+	Avg      = "avg"
+	Min      = "min"
+	Max      = "max"
+	Sum      = "sum"
+	Count    = "count"
+	Last     = "last"
+	P50      = "p50"
+	P95      = "p95"
+	P99      = "p99"
+	Rate     = "rate"     // counters only, increase per second
+	Increase = "increase" // counters only
+)
+
+// MaxBuckets limits number of buckets returned by one query.
+const MaxBuckets = 11000
+
+// Query errors
+var (
+	ErrBadRange       = errors.New("range end should not be before its start and step should be positive")
+	ErrTooManyBuckets = fmt.Errorf("query would return more than %d buckets, increase step", MaxBuckets)
+	ErrAggregation    = errors.New("unknown aggregation function")
+	ErrCounterOnly    = errors.New("aggregation function is available for counters only")
+)
+
+// Request struct describes range query. Buckets are aligned to multiples of step since unix epoch,
+// the first bucket contains From and the last one contains To.
+type Request struct {
+	ID          string
+	MType       string
+	Labels      map[string]string
+	From        time.Time
+	To          time.Time
+	Step        time.Duration
+	Aggregation string
+}
+
+// Result struct keeps aggregated values of non-empty buckets, every point timestamp is the bucket start.
+type Result struct {
+	ID          string            `json:"id"`
+	MType       string            `json:"type"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Aggregation string            `json:"aggregation"`
+	Step        float64           `json:"step"`
+	Points      []models.Sample   `json:"points"`
+}
+
+// Validate checks request settings without reading storage.
+func (req Request) Validate() error {
+	if req.Step <= 0 || req.To.Before(req.From) {
+		return ErrBadRange
+	}
+	if req.align(req.To).Sub(req.align(req.From))/req.Step >= MaxBuckets {
+		return ErrTooManyBuckets
+	}
+
+	switch req.Aggregation {
+	case Avg, Min, Max, Sum, Count, Last, P50, P95, P99:
+		return nil
+	case Rate, Increase:
+		if req.MType != "counter" {
+			return ErrCounterOnly
+		}
+		return nil
+	default:
+		return ErrAggregation
+	}
+}
+
+func (req Request) align(t time.Time) time.Time {
+	return time.Unix(0, t.UnixNano()-mod(t.UnixNano(), int64(req.Step))).UTC()
+}
+
+func mod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+// Run reads samples from storage and aggregates them into buckets.
+// Counter increase of the first bucket is counted from the last sample of the step before it.
+func Run(ctx context.Context, s storage.Storage, req Request) (*Result, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	start := req.align(req.From)
+	samples, err := s.ReadSamples(ctx, req.ID, req.MType, req.Labels, start.Add(-req.Step), req.To)
+	if err != nil {
+		return nil, err
+	}
+
+	return Aggregate(samples, req), nil
+}
+
+// Aggregate splits samples sorted by time into buckets and applies request aggregation to every non-empty bucket.
+// Samples before the first bucket are used only as a base of counter increase.
+func Aggregate(samples []models.Sample, req Request) *Result {
+	result := &Result{
+		ID:          req.ID,
+		MType:       req.MType,
+		Labels:      req.Labels,
+		Aggregation: req.Aggregation,
+		Step:        req.Step.Seconds(),
+		Points:      make([]models.Sample, 0),
+	}
+
+	start := req.align(req.From)
+	var prev *models.Sample
+	i := 0
+	for ; i < len(samples) && samples[i].Timestamp.Before(start); i++ {
+		prev = &samples[i]
+	}
+
+	for bucket := start; !bucket.After(req.To); bucket = bucket.Add(req.Step) {
+		end := bucket.Add(req.Step)
+		if end.After(req.To) {
+			end = req.To.Add(time.Nanosecond)
+		}
+
+		j := i
+		for j < len(samples) && samples[j].Timestamp.Before(end) {
+			j++
+		}
+		if j == i {
+			continue
+		}
+
+		result.Points = append(result.Points, models.Sample{
+			Timestamp: bucket,
+			Value:     aggregate(req.Aggregation, prev, samples[i:j], req.Step),
+		})
+		prev = &samples[j-1]
+		i = j
+	}
+
+	return result
+}
+
+func aggregate(fn string, prev *models.Sample, bucket []models.Sample, step time.Duration) float64 {
+	switch fn {
+	case Count:
+		return float64(len(bucket))
+	case Last:
+		return bucket[len(bucket)-1].Value
+	case Sum, Avg:
+		var sum float64
+		for _, s := range bucket {
+			sum += s.Value
+		}
+		if fn == Avg {
+			return sum / float64(len(bucket))
+		}
+		return sum
+	case Min, Max, P50, P95, P99:
+		values := make([]float64, len(bucket))
+		for i, s := range bucket {
+			values[i] = s.Value
+		}
+		slices.Sort(values)
+		return percentile(fn, values)
+	case Increase:
+		return increase(prev, bucket)
+	case Rate:
+		return increase(prev, bucket) / step.Seconds()
+	}
+
+	return math.NaN()
+}
+
+// percentile uses nearest rank method like agent StatsD timers do.
+func percentile(fn string, sorted []float64) float64 {
+	rank := map[string]float64{Min: 0, P50: 0.5, P95: 0.95, P99: 0.99, Max: 1}[fn]
+	i := int(math.Ceil(rank*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// increase sums counter growth, value drop means the counter was reset and grew from zero.
+func increase(prev *models.Sample, bucket []models.Sample) float64 {
+	base := bucket[0].Value
+	if prev != nil {
+		base = prev.Value
+	}
+
+	var total float64
+	for _, s := range bucket {
+		if s.Value < base {
+			base = 0
+		}
+		total += s.Value - base
+		base = s.Value
+	}
+
+	return total
+}
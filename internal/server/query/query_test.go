@@ -0,0 +1,130 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aykuli/observer/internal/models"
+)
+
+func TestAggregate(t *testing.T) {
+	base := time.Unix(1700000040, 0).UTC() // multiple of minute
+	at := func(seconds int, value float64) models.Sample {
+		return models.Sample{Timestamp: base.Add(time.Duration(seconds) * time.Second), Value: value}
+	}
+
+	gauges := []models.Sample{
+		at(0, 4), at(10, 1), at(20, 3), at(30, 2),
+		at(70, 10),
+		at(190, 7), at(200, 5),
+	}
+	counters := []models.Sample{
+		at(-10, 100),
+		at(0, 110), at(30, 130),
+		at(60, 140), at(90, 5), at(100, 25), // reset to zero between 60 and 90
+		at(150, 40),
+	}
+
+	tests := []struct {
+		name    string
+		samples []models.Sample
+		req     Request
+		want    []models.Sample
+	}{
+		{
+			name:    "avg with empty bucket skipped",
+			samples: gauges,
+			req:     Request{From: base.Add(15 * time.Second), To: base.Add(200 * time.Second), Step: time.Minute, Aggregation: Avg},
+			want:    []models.Sample{at(0, 2.5), at(60, 10), at(180, 6)},
+		},
+		{
+			name:    "min",
+			samples: gauges,
+			req:     Request{From: base, To: base.Add(59 * time.Second), Step: time.Minute, Aggregation: Min},
+			want:    []models.Sample{at(0, 1)},
+		},
+		{
+			name:    "max",
+			samples: gauges,
+			req:     Request{From: base, To: base.Add(59 * time.Second), Step: time.Minute, Aggregation: Max},
+			want:    []models.Sample{at(0, 4)},
+		},
+		{
+			name:    "sum till the range end",
+			samples: gauges,
+			req:     Request{From: base, To: base.Add(190 * time.Second), Step: 2 * time.Minute, Aggregation: Sum},
+			want:    []models.Sample{at(0, 20), at(120, 7)},
+		},
+		{
+			name:    "count",
+			samples: gauges,
+			req:     Request{From: base, To: base.Add(200 * time.Second), Step: 2 * time.Minute, Aggregation: Count},
+			want:    []models.Sample{at(0, 5), at(120, 2)},
+		},
+		{
+			name:    "last",
+			samples: gauges,
+			req:     Request{From: base, To: base.Add(200 * time.Second), Step: 2 * time.Minute, Aggregation: Last},
+			want:    []models.Sample{at(0, 10), at(120, 5)},
+		},
+		{
+			name:    "percentiles",
+			samples: gauges,
+			req:     Request{From: base, To: base.Add(59 * time.Second), Step: time.Minute, Aggregation: P50},
+			want:    []models.Sample{at(0, 2)},
+		},
+		{
+			name:    "p99",
+			samples: gauges,
+			req:     Request{From: base, To: base.Add(59 * time.Second), Step: time.Minute, Aggregation: P99},
+			want:    []models.Sample{at(0, 4)},
+		},
+		{
+			name:    "increase counts from previous step and handles reset",
+			samples: counters,
+			req:     Request{MType: "counter", From: base, To: base.Add(179 * time.Second), Step: time.Minute, Aggregation: Increase},
+			want:    []models.Sample{at(0, 30), at(60, 35), at(120, 15)},
+		},
+		{
+			name:    "rate",
+			samples: counters,
+			req:     Request{MType: "counter", From: base, To: base.Add(59 * time.Second), Step: time.Minute, Aggregation: Rate},
+			want:    []models.Sample{at(0, 0.5)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.req.Validate())
+			result := Aggregate(tt.samples, tt.req)
+			assert.Equal(t, tt.want, result.Points)
+			assert.Equal(t, tt.req.Step.Seconds(), result.Step)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		req  Request
+		err  error
+	}{
+		{name: "valid", req: Request{MType: "gauge", From: now.Add(-time.Hour), To: now, Step: time.Minute, Aggregation: P95}},
+		{name: "zero step", req: Request{MType: "gauge", From: now, To: now, Aggregation: Avg}, err: ErrBadRange},
+		{name: "reversed range", req: Request{MType: "gauge", From: now, To: now.Add(-time.Hour), Step: time.Minute, Aggregation: Avg}, err: ErrBadRange},
+		{name: "too many buckets", req: Request{MType: "gauge", From: now.Add(-24 * time.Hour), To: now, Step: time.Second, Aggregation: Avg}, err: ErrTooManyBuckets},
+		{name: "unknown function", req: Request{MType: "gauge", From: now, To: now, Step: time.Minute, Aggregation: "median"}, err: ErrAggregation},
+		{name: "rate of gauge", req: Request{MType: "gauge", From: now, To: now, Step: time.Minute, Aggregation: Rate}, err: ErrCounterOnly},
+		{name: "increase of counter", req: Request{MType: "counter", From: now, To: now, Step: time.Minute, Aggregation: Increase}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.req.Validate(), tt.err)
+		})
+	}
+}
@@ -4,6 +4,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -16,14 +17,57 @@ var (
 		name VARCHAR NOT NULL,
 		type TEXT NOT NULL,
 	  value FLOAT,
-		delta BIGINT)`
-	selectAllLastMetricsQuery    = `SELECT name, type, value, delta FROM metrics ORDER BY name`
-	findByMetricNameAndTypeQuery = `SELECT value, delta FROM metrics WHERE name=@name AND type=@type`
-
-	updateGaugeQuery          = `UPDATE metrics SET value = @value WHERE name=@name AND type='gauge' RETURNING value`
-	updateCounterQuery        = `UPDATE metrics SET delta = delta + @delta WHERE name=@name AND type='counter' RETURNING delta`
-	insertMetricQuery         = `INSERT INTO metrics (name, type, value, delta) VALUES (@name, @type, @value, @delta) RETURNING value, delta`
-	checkMetricExistanceQuery = `SELECT count(*) FROM metrics WHERE name=@name AND type=@type`
+		delta BIGINT,
+		labels VARCHAR NOT NULL DEFAULT '')`
+	// labels keep models.LabelsString of metric labels, so metrics are identified by name, type and labels.
+	alterMetricsLabelsQuery      = `ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels VARCHAR NOT NULL DEFAULT ''`
+	selectAllLastMetricsQuery    = `SELECT name, type, value, delta, labels FROM metrics ORDER BY name, labels`
+	findByMetricNameAndTypeQuery = `SELECT value, delta FROM metrics WHERE name=@name AND type=@type AND labels=@labels`
+
+	updateGaugeQuery   = `UPDATE metrics SET value = @value WHERE name=@name AND type='gauge' AND labels=@labels RETURNING value`
+	updateCounterQuery = `UPDATE metrics SET delta = delta + @delta
+		WHERE name=@name AND type='counter' AND labels=@labels RETURNING delta`
+	insertMetricQuery = `INSERT INTO metrics (name, type, value, delta, labels)
+		VALUES (@name, @type, @value, @delta, @labels) RETURNING value, delta`
+	checkMetricExistanceQuery = `SELECT count(*) FROM metrics WHERE name=@name AND type=@type AND labels=@labels`
+
+	createHistoryTableQuery = `CREATE TABLE IF NOT EXISTS metrics_history (
+		name VARCHAR NOT NULL,
+		type TEXT NOT NULL,
+		value DOUBLE PRECISION NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		resolution BIGINT NOT NULL DEFAULT 0,
+		labels VARCHAR NOT NULL DEFAULT '')`
+	alterHistoryResolutionQuery = `ALTER TABLE metrics_history ADD COLUMN IF NOT EXISTS resolution BIGINT NOT NULL DEFAULT 0`
+	alterHistoryLabelsQuery     = `ALTER TABLE metrics_history ADD COLUMN IF NOT EXISTS labels VARCHAR NOT NULL DEFAULT ''`
+	dropHistoryIndexQuery       = `DROP INDEX IF EXISTS metrics_history_name_type_created_at_idx`
+	createHistoryIndexQuery     = `CREATE INDEX IF NOT EXISTS metrics_history_series_created_at_idx
+		ON metrics_history (name, type, labels, created_at)`
+	createHistoryTimeIndexQuery = `CREATE INDEX IF NOT EXISTS metrics_history_created_at_idx ON metrics_history (created_at)`
+	// clock_timestamp is taken after the metrics row is locked by update, so samples of one metric are in saving order.
+	insertSampleQuery = `INSERT INTO metrics_history (name, type, labels, value, created_at)
+		VALUES (@name, @type, @labels, @value, clock_timestamp())`
+	selectSamplesQuery = `SELECT created_at, value FROM metrics_history
+		WHERE name=@name AND type=@type AND labels=@labels AND created_at BETWEEN @from AND @to ORDER BY created_at`
+
+	deleteSamplesBatchQuery = `DELETE FROM metrics_history WHERE ctid IN (
+		SELECT ctid FROM metrics_history WHERE created_at < @before LIMIT @limit)`
+	selectRollupSeriesQuery = `SELECT DISTINCT name, type, labels FROM metrics_history
+		WHERE resolution < @resolution AND created_at < @before`
+	// Samples of finer resolution are replaced with one sample per bucket: average of gauges or the last counter total.
+	rollupSeriesQuery = `WITH rolled AS (
+			DELETE FROM metrics_history
+			WHERE name=@name AND type=@type AND labels=@labels AND resolution < @resolution AND created_at < @before
+			RETURNING value, created_at
+		), bucketed AS (
+			SELECT value, created_at, to_timestamp(floor(extract(epoch FROM created_at) / @resolution) * @resolution) AS bucket
+			FROM rolled
+		)
+		INSERT INTO metrics_history (name, type, labels, value, created_at, resolution)
+		SELECT @name::varchar, @type::text, @labels::varchar,
+			CASE WHEN @type::text = 'counter' THEN (array_agg(value ORDER BY created_at DESC))[1] ELSE avg(value) END,
+			bucket, @resolution::bigint
+		FROM bucketed GROUP BY bucket`
 )
 
 // MetricDB type provides struct to work with metric in database rows.
@@ -42,9 +86,22 @@ func NewMetricsRepository(client *pgxpool.Conn) *MetricsRepository {
 	return &MetricsRepository{client}
 }
 
+// InitTable creates metrics table keeping the last values and metrics_history table keeping every saved value.
 func (r *MetricsRepository) InitTable(ctx context.Context) error {
-	if _, err := r.conn.Exec(ctx, createMetricsTableQuery); err != nil {
-		return err
+	queries := []string{
+		createMetricsTableQuery,
+		alterMetricsLabelsQuery,
+		createHistoryTableQuery,
+		alterHistoryResolutionQuery,
+		alterHistoryLabelsQuery,
+		dropHistoryIndexQuery,
+		createHistoryIndexQuery,
+		createHistoryTimeIndexQuery,
+	}
+	for _, query := range queries {
+		if _, err := r.conn.Exec(ctx, query); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -61,8 +118,12 @@ func (r *MetricsRepository) SelectAllValues(ctx context.Context) ([]models.Metri
 		var m models.Metric
 		var value sql.NullFloat64
 		var delta sql.NullInt64
+		var labels string
 
-		if err = result.Scan(&m.ID, &m.MType, &value, &delta); err != nil {
+		if err = result.Scan(&m.ID, &m.MType, &value, &delta, &labels); err != nil {
+			return nil, err
+		}
+		if m.Labels, err = models.ParseLabels(labels); err != nil {
 			return nil, err
 		}
 		if value.Valid {
@@ -81,10 +142,10 @@ func (r *MetricsRepository) SelectAllValues(ctx context.Context) ([]models.Metri
 	return metrics, nil
 }
 
-func (r *MetricsRepository) FindByNameAndType(ctx context.Context, mName, mType string) (*models.Metric, error) {
-	outMt := models.Metric{ID: mName, MType: mType}
+func (r *MetricsRepository) FindByNameAndType(ctx context.Context, mName, mType string, labels map[string]string) (*models.Metric, error) {
+	outMt := models.Metric{ID: mName, MType: mType, Labels: labels}
 
-	args := pgx.NamedArgs{"name": mName, "type": mType}
+	args := pgx.NamedArgs{"name": mName, "type": mType, "labels": models.LabelsString(labels)}
 	result := r.conn.QueryRow(ctx, findByMetricNameAndTypeQuery, args)
 	var metricValue sql.NullFloat64
 	var metricDelta sql.NullInt64
@@ -106,10 +167,10 @@ func (r *MetricsRepository) FindByNameAndType(ctx context.Context, mName, mType
 	return &outMt, nil
 }
 
-// Save update metric if it exists else insert it
+// Save update metric if it exists else insert it. Saved value is recorded to history.
 func (r *MetricsRepository) Save(ctx context.Context, tx pgx.Tx, metric models.Metric) (*models.Metric, error) {
 	var outMt *models.Metric
-	exist, err := r.exist(ctx, tx, metric.ID, metric.MType)
+	exist, err := r.exist(ctx, tx, metric)
 	if err != nil {
 		return nil, err
 	}
@@ -123,12 +184,59 @@ func (r *MetricsRepository) Save(ctx context.Context, tx pgx.Tx, metric models.M
 		return nil, err
 	}
 
+	if err = r.insertSample(ctx, tx, *outMt); err != nil {
+		return nil, err
+	}
+
 	return outMt, nil
 }
 
-func (r *MetricsRepository) exist(ctx context.Context, tx pgx.Tx, mName, mType string) (bool, error) {
+// insertSample records gauge value or counter total.
+func (r *MetricsRepository) insertSample(ctx context.Context, tx pgx.Tx, metric models.Metric) error {
+	var value float64
+	switch {
+	case metric.MType == "gauge" && metric.Value != nil:
+		value = *metric.Value
+	case metric.MType == "counter" && metric.Delta != nil:
+		value = float64(*metric.Delta)
+	default:
+		return pgx.ErrNoRows
+	}
+
+	args := pgx.NamedArgs{"name": metric.ID, "type": metric.MType, "labels": models.LabelsString(metric.Labels), "value": value}
+	_, err := tx.Exec(ctx, insertSampleQuery, args)
+	return err
+}
+
+// SelectSamples returns metric samples saved from `from` till `to` inclusively in time order.
+func (r *MetricsRepository) SelectSamples(ctx context.Context, mName, mType string, labels map[string]string, from, to time.Time) ([]models.Sample, error) {
+	samples := make([]models.Sample, 0)
+
+	args := pgx.NamedArgs{"name": mName, "type": mType, "labels": models.LabelsString(labels), "from": from, "to": to}
+	result, err := r.conn.Query(ctx, selectSamplesQuery, args)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var sample models.Sample
+		if err = result.Scan(&sample.Timestamp, &sample.Value); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+func (r *MetricsRepository) exist(ctx context.Context, tx pgx.Tx, metric models.Metric) (bool, error) {
 	var exist int
-	result := tx.QueryRow(ctx, checkMetricExistanceQuery, pgx.NamedArgs{"name": mName, "type": mType})
+	args := pgx.NamedArgs{"name": metric.ID, "type": metric.MType, "labels": models.LabelsString(metric.Labels)}
+	result := tx.QueryRow(ctx, checkMetricExistanceQuery, args)
 	err := result.Scan(&exist)
 	if err != nil {
 		return false, err
@@ -145,10 +253,10 @@ func (r *MetricsRepository) insert(tx pgx.Tx, ctx context.Context, metric models
 
 	if metric.MType == "gauge" {
 		value = *metric.Value
-		args = pgx.NamedArgs{"name": metric.ID, "type": "gauge", "value": value, "delta": nil}
+		args = pgx.NamedArgs{"name": metric.ID, "type": "gauge", "value": value, "delta": nil, "labels": models.LabelsString(metric.Labels)}
 	} else if metric.MType == "counter" {
 		delta = *metric.Delta
-		args = pgx.NamedArgs{"name": metric.ID, "type": "counter", "value": nil, "delta": delta}
+		args = pgx.NamedArgs{"name": metric.ID, "type": "counter", "value": nil, "delta": delta, "labels": models.LabelsString(metric.Labels)}
 
 	} else {
 		return nil, pgx.ErrNoRows
@@ -173,10 +281,11 @@ func (r *MetricsRepository) insert(tx pgx.Tx, ctx context.Context, metric models
 }
 
 func (r *MetricsRepository) update(tx pgx.Tx, ctx context.Context, metric models.Metric) (*models.Metric, error) {
-	outMt := models.Metric{ID: metric.ID, MType: metric.MType}
+	outMt := models.Metric{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
+	labels := models.LabelsString(metric.Labels)
 
 	if metric.MType == "gauge" {
-		args := pgx.NamedArgs{"name": metric.ID, "type": "gauge", "value": &metric.Value, "delta": nil}
+		args := pgx.NamedArgs{"name": metric.ID, "type": "gauge", "value": &metric.Value, "delta": nil, "labels": labels}
 		if _, err := tx.Exec(ctx, updateGaugeQuery, args); err != nil {
 			return &outMt, err
 		}
@@ -184,7 +293,7 @@ func (r *MetricsRepository) update(tx pgx.Tx, ctx context.Context, metric models
 		outMt.Value = metric.Value
 		return &outMt, nil
 	} else if metric.MType == "counter" {
-		result := tx.QueryRow(ctx, updateCounterQuery, pgx.NamedArgs{"name": metric.ID, "delta": &metric.Delta})
+		result := tx.QueryRow(ctx, updateCounterQuery, pgx.NamedArgs{"name": metric.ID, "delta": &metric.Delta, "labels": labels})
 		var delta int64
 		if err := result.Scan(&delta); err != nil {
 			return &outMt, err
@@ -209,3 +318,60 @@ func (r *MetricsRepository) SaveBatch(ctx context.Context, tx pgx.Tx, metrics []
 	}
 	return outMts, nil
 }
+
+// DeleteSamplesBefore deletes samples older than `before` by batches of limit rows, so table isn't locked for long.
+func (r *MetricsRepository) DeleteSamplesBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var deleted int64
+	for {
+		tag, err := r.conn.Exec(ctx, deleteSamplesBatchQuery, pgx.NamedArgs{"before": before, "limit": limit})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += tag.RowsAffected()
+		if tag.RowsAffected() < int64(limit) {
+			return deleted, nil
+		}
+	}
+}
+
+// SelectRollupSeries returns name, type and labels of metrics having samples finer than resolution saved before `before`.
+func (r *MetricsRepository) SelectRollupSeries(ctx context.Context, resolution time.Duration, before time.Time) ([]models.Metric, error) {
+	metrics := make([]models.Metric, 0)
+
+	args := pgx.NamedArgs{"resolution": int64(resolution.Seconds()), "before": before}
+	result, err := r.conn.Query(ctx, selectRollupSeriesQuery, args)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var m models.Metric
+		var labels string
+		if err = result.Scan(&m.ID, &m.MType, &labels); err != nil {
+			return nil, err
+		}
+		if m.Labels, err = models.ParseLabels(labels); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// RollupSeries replaces metric samples finer than resolution saved before `before` with resolution rollups.
+func (r *MetricsRepository) RollupSeries(ctx context.Context, tx pgx.Tx, metric models.Metric, resolution time.Duration, before time.Time) error {
+	args := pgx.NamedArgs{
+		"name":       metric.ID,
+		"type":       metric.MType,
+		"labels":     models.LabelsString(metric.Labels),
+		"resolution": int64(resolution.Seconds()),
+		"before":     before,
+	}
+	_, err := tx.Exec(ctx, rollupSeriesQuery, args)
+	return err
+}
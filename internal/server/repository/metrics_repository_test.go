@@ -2,9 +2,10 @@ package repository
 
 import (
 	"context"
-	"fmt"
 	"math/rand"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
@@ -18,15 +19,9 @@ var envFolder = "../../../.env"
 
 func TestMetricsRepository(t *testing.T) {
 	ctx := context.Background()
-	dsn := config.Options.DatabaseDsn
+	dsn := testDSN()
 	if dsn == "" {
-		envFile, err := godotenv.Read(envFolder)
-		fmt.Println(envFile)
-		require.NoError(t, err)
-		dsn = envFile["POSTGRES_TEST_DSN"]
-	}
-	if dsn == "" {
-		return
+		t.Skip("no test database: set DATABASE_DSN or POSTGRES_TEST_DSN in environment or .env file")
 	}
 	pool, err := pgxpool.New(ctx, dsn)
 	require.NoError(t, err)
@@ -78,4 +73,24 @@ func TestMetricsRepository(t *testing.T) {
 	require.Contains(t, metrics, metric)
 	require.Contains(t, metrics, metricsBatch[0])
 	require.Contains(t, metrics, metricsBatch[1])
+
+	samples, err := repository.SelectSamples(ctx, "test_1", "gauge", nil, time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.NotEmpty(t, samples)
+	require.Equal(t, values[0], samples[len(samples)-1].Value)
+}
+
+// testDSN returns test database source name from flags, environment or .env file.
+func testDSN() string {
+	if config.Options.DatabaseDsn != "" {
+		return config.Options.DatabaseDsn
+	}
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		return dsn
+	}
+	envFile, err := godotenv.Read(envFolder)
+	if err != nil {
+		return ""
+	}
+	return envFile["POSTGRES_TEST_DSN"]
 }
@@ -0,0 +1,143 @@
+// Package retention provides downsampling and removal of old metric samples.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PolicyNone disables compaction, samples are kept forever.
+const PolicyNone = "none"
+
+// Tier struct describes samples resolution kept till they are older than retention.
+// Zero resolution means raw samples.
+type Tier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// Policy keeps tiers sorted from the finest resolution to the coarsest one.
+// Samples older than the last tier retention are deleted.
+type Policy []Tier
+
+// ParsePolicy parses policy like "raw:48h,1m:30d,1h:365d". Durations accept "d" suffix for days,
+// resolutions should be whole seconds. Empty string or "none" means no policy.
+func ParsePolicy(s string) (Policy, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == PolicyNone {
+		return nil, nil
+	}
+
+	var policy Policy
+	for _, part := range strings.Split(s, ",") {
+		resolution, retention, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("retention tier %q should look like resolution:retention", part)
+		}
+
+		var tier Tier
+		var err error
+		if resolution != "raw" {
+			tier.Resolution, err = parseDuration(resolution)
+			if err != nil || tier.Resolution < time.Second || tier.Resolution%time.Second != 0 {
+				return nil, fmt.Errorf("wrong resolution of retention tier %q", part)
+			}
+		}
+		if tier.Retention, err = parseDuration(retention); err != nil || tier.Retention <= 0 {
+			return nil, fmt.Errorf("wrong retention of retention tier %q", part)
+		}
+
+		if n := len(policy); n > 0 {
+			prev := policy[n-1]
+			if tier.Resolution <= prev.Resolution || tier.Retention <= prev.Retention {
+				return nil, fmt.Errorf("retention tier %q should be coarser and longer than the previous one", part)
+			}
+		}
+		policy = append(policy, tier)
+	}
+
+	return policy, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(s)
+}
+
+// DeleteBefore returns time, samples saved before it are deleted.
+func (p Policy) DeleteBefore(now time.Time) time.Time {
+	return now.Add(-p[len(p)-1].Retention)
+}
+
+// RollupBefore returns time, samples saved before it are rolled up to tier i resolution. The time is aligned
+// to the resolution, so every rollup bucket is made from all its samples at once. Raw tier has no rollups,
+// zero time is returned for it.
+func (p Policy) RollupBefore(i int, now time.Time) time.Time {
+	if p[i].Resolution == 0 {
+		return time.Time{}
+	}
+
+	var finer time.Duration
+	if i > 0 {
+		finer = p[i-1].Retention
+	}
+	return Align(now.Add(-finer), p[i].Resolution)
+}
+
+// Align returns start of the resolution bucket t belongs to. Buckets start at multiples of resolution since unix epoch.
+func Align(t time.Time, resolution time.Duration) time.Time {
+	ns := t.UnixNano()
+	m := ns % int64(resolution)
+	if m < 0 {
+		m += int64(resolution)
+	}
+	return time.Unix(0, ns-m).UTC()
+}
+
+// Store is implemented by storages able to downsample and delete their samples according to policy.
+// Gauge rollup value is average of the bucket samples, counter rollup value is the last total.
+type Store interface {
+	Compact(ctx context.Context, policy Policy, now time.Time) error
+}
+
+// Compactor struct runs store compaction periodically.
+type Compactor struct {
+	store    Store
+	policy   Policy
+	interval time.Duration
+	logger   zap.SugaredLogger
+}
+
+// NewCompactor creates Compactor applying policy to store every interval.
+func NewCompactor(store Store, policy Policy, interval time.Duration, logger zap.SugaredLogger) *Compactor {
+	return &Compactor{store: store, policy: policy, interval: interval, logger: logger}
+}
+
+// Run compacts store on start and every interval till context is done.
+func (c *Compactor) Run(ctx context.Context) {
+	if len(c.policy) == 0 || c.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.store.Compact(ctx, c.policy, time.Now()); err != nil && ctx.Err() == nil {
+			c.logger.Errorln("failed samples compaction", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package retention
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("raw:48h, 1m:30d, 1h:365d")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{
+		{Resolution: 0, Retention: 48 * time.Hour},
+		{Resolution: time.Minute, Retention: 30 * 24 * time.Hour},
+		{Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+	}, policy)
+
+	for _, s := range []string{"", "none"} {
+		policy, err = ParsePolicy(s)
+		require.NoError(t, err)
+		assert.Empty(t, policy)
+	}
+
+	for _, s := range []string{
+		"raw",
+		"raw:forever",
+		"raw:48h,raw:72h",
+		"raw:48h,1h:30d,1m:365d",
+		"raw:48h,1m:24h",
+		"500ms:1h",
+		"1m:-1h",
+	} {
+		_, err = ParsePolicy(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPolicyBoundaries(t *testing.T) {
+	policy, err := ParsePolicy("raw:48h,1m:30d,1h:365d")
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 10, 12, 30, 45, 0, time.UTC)
+
+	assert.Equal(t, now.Add(-365*24*time.Hour), policy.DeleteBefore(now))
+	assert.True(t, policy.RollupBefore(0, now).IsZero())
+	assert.Equal(t, time.Date(2024, 6, 8, 12, 30, 0, 0, time.UTC), policy.RollupBefore(1, now))
+	assert.Equal(t, time.Date(2024, 5, 11, 12, 0, 0, 0, time.UTC), policy.RollupBefore(2, now))
+
+	assert.Equal(t, time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC), Align(now, time.Hour))
+	assert.Equal(t, time.Unix(-60, 0).UTC(), Align(time.Unix(-1, 0), time.Minute))
+}
+
+type countingStore struct {
+	calls atomic.Int32
+}
+
+func (s *countingStore) Compact(ctx context.Context, policy Policy, now time.Time) error {
+	s.calls.Add(1)
+	return nil
+}
+
+func TestCompactor(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+
+	policy, err := ParsePolicy("raw:1h")
+	require.NoError(t, err)
+
+	store := &countingStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewCompactor(store, policy, 10*time.Millisecond, *logger.Sugar()).Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return store.calls.Load() >= 2 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	disabled := &countingStore{}
+	NewCompactor(disabled, nil, time.Millisecond, *logger.Sugar()).Run(context.Background())
+	assert.Zero(t, disabled.calls.Load(), "empty policy doesn't compact")
+}
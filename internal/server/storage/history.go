@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/retention"
+)
+
+// Default history settings
+const (
+	HistorySizeDefault = 10000 // samples kept per metric
+	HistoryUnlimited   = -1    // samples are kept till retention policy removes them
+	chunkSize          = 128   // samples per chunk
+)
+
+// chunk keeps samples in time order. Only the last chunk of the series is appended to.
+type chunk struct {
+	samples []models.Sample
+}
+
+func (c *chunk) first() time.Time {
+	return c.samples[0].Timestamp
+}
+
+func (c *chunk) last() time.Time {
+	return c.samples[len(c.samples)-1].Timestamp
+}
+
+// series keeps metric samples split into chunks, so the oldest samples are dropped a chunk at a time.
+type series struct {
+	mType  string
+	chunks []*chunk
+	size   int
+}
+
+// History struct keeps timestamped samples of every metric in memory.
+// Number of samples per metric is limited, the oldest chunk is dropped when the rest keeps enough samples.
+type History struct {
+	mutex      sync.RWMutex
+	series     map[string]*series
+	maxSamples int
+	now        func() time.Time
+}
+
+// NewHistory creates History keeping the last maxSamples of every metric at least. Zero maxSamples means default limit,
+// HistoryUnlimited keeps all samples, it is used with retention policy bounding the history instead.
+func NewHistory(maxSamples int) *History {
+	if maxSamples == 0 {
+		maxSamples = HistorySizeDefault
+	}
+	return &History{
+		series:     make(map[string]*series),
+		maxSamples: maxSamples,
+		now:        time.Now,
+	}
+}
+
+func seriesKey(mName, mType string) string {
+	return mType + "/" + mName
+}
+
+// Append records metric value with current time. Time never goes backwards inside series,
+// if system clock does, the sample gets the timestamp of the previous one. Timestamps keep microseconds
+// like database TIMESTAMPTZ does, so both storages put samples on bucket edges into the same buckets.
+func (h *History) Append(mName, mType string, value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := seriesKey(mName, mType)
+	s, ok := h.series[key]
+	if !ok {
+		s = &series{mType: mType}
+		h.series[key] = s
+	}
+
+	ts := h.now().Truncate(time.Microsecond)
+	var tail *chunk
+	if len(s.chunks) > 0 {
+		tail = s.chunks[len(s.chunks)-1]
+		if last := tail.last(); ts.Before(last) {
+			ts = last
+		}
+	}
+	if tail == nil || len(tail.samples) == chunkSize {
+		tail = &chunk{samples: make([]models.Sample, 0, chunkSize)}
+		s.chunks = append(s.chunks, tail)
+	}
+	tail.samples = append(tail.samples, models.Sample{Timestamp: ts, Value: value})
+	s.size++
+
+	for h.maxSamples > 0 && s.size-len(s.chunks[0].samples) >= h.maxSamples {
+		s.size -= len(s.chunks[0].samples)
+		s.chunks[0] = nil
+		s.chunks = s.chunks[1:]
+	}
+}
+
+// Range returns metric samples recorded from `from` till `to` inclusively in time order.
+func (h *History) Range(mName, mType string, from, to time.Time) []models.Sample {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	samples := make([]models.Sample, 0)
+	s, ok := h.series[seriesKey(mName, mType)]
+	if !ok || to.Before(from) {
+		return samples
+	}
+
+	start := sort.Search(len(s.chunks), func(i int) bool { return !s.chunks[i].last().Before(from) })
+	for _, c := range s.chunks[start:] {
+		if c.first().After(to) {
+			break
+		}
+		i := sort.Search(len(c.samples), func(i int) bool { return !c.samples[i].Timestamp.Before(from) })
+		for ; i < len(c.samples) && !c.samples[i].Timestamp.After(to); i++ {
+			samples = append(samples, c.samples[i])
+		}
+	}
+
+	return samples
+}
+
+// Compact replaces samples with rollups of policy tiers and deletes samples older than policy retention.
+// Metrics without samples left are forgotten.
+func (h *History) Compact(policy retention.Policy, now time.Time) {
+	if len(policy) == 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	deleteBefore := policy.DeleteBefore(now)
+	rollupBefore := make([]time.Time, len(policy))
+	for i := range policy {
+		rollupBefore[i] = policy.RollupBefore(i, now)
+	}
+
+	for key, s := range h.series {
+		compacted := make([]models.Sample, 0, s.size)
+		var bucket []models.Sample
+		var bucketStart time.Time
+		flush := func() {
+			if len(bucket) > 0 {
+				compacted = append(compacted, models.Sample{Timestamp: bucketStart, Value: rollup(s.mType, bucket)})
+				bucket = bucket[:0]
+			}
+		}
+
+		for _, c := range s.chunks {
+			for _, sample := range c.samples {
+				if sample.Timestamp.Before(deleteBefore) {
+					continue
+				}
+
+				tier := -1
+				for i := len(policy) - 1; i >= 0; i-- {
+					if sample.Timestamp.Before(rollupBefore[i]) {
+						tier = i
+						break
+					}
+				}
+				if tier < 0 {
+					flush()
+					compacted = append(compacted, sample)
+					continue
+				}
+
+				start := retention.Align(sample.Timestamp, policy[tier].Resolution)
+				if len(bucket) > 0 && !start.Equal(bucketStart) {
+					flush()
+				}
+				bucketStart = start
+				bucket = append(bucket, sample)
+			}
+		}
+		flush()
+
+		if len(compacted) == 0 {
+			delete(h.series, key)
+			continue
+		}
+		s.chunks = s.chunks[:0]
+		for i := 0; i < len(compacted); i += chunkSize {
+			c := &chunk{samples: make([]models.Sample, 0, chunkSize)}
+			c.samples = append(c.samples, compacted[i:min(i+chunkSize, len(compacted))]...)
+			s.chunks = append(s.chunks, c)
+		}
+		s.size = len(compacted)
+	}
+}
+
+// rollup returns average of gauge samples or the last counter total.
+func rollup(mType string, samples []models.Sample) float64 {
+	if mType == "counter" {
+		return samples[len(samples)-1].Value
+	}
+
+	var sum float64
+	for _, s := range samples {
+		sum += s.Value
+	}
+	return sum / float64(len(samples))
+}
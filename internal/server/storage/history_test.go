@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/retention"
+)
+
+func TestHistory(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	now := start
+	h := NewHistory(200)
+	h.now = func() time.Time { return now }
+
+	for i := 0; i < 300; i++ {
+		h.Append("temp", "gauge", float64(i))
+		now = now.Add(time.Second)
+	}
+
+	t.Run("oldest chunks are dropped", func(t *testing.T) {
+		samples := h.Range("temp", "gauge", start, now)
+		require.GreaterOrEqual(t, len(samples), 200)
+		require.Less(t, len(samples), 200+chunkSize)
+		assert.Equal(t, float64(299), samples[len(samples)-1].Value)
+		assert.Equal(t, float64(300-len(samples)), samples[0].Value)
+	})
+
+	t.Run("range bounds are inclusive", func(t *testing.T) {
+		samples := h.Range("temp", "gauge", start.Add(250*time.Second), start.Add(260*time.Second))
+		require.Len(t, samples, 11)
+		assert.Equal(t, float64(250), samples[0].Value)
+		assert.Equal(t, float64(260), samples[10].Value)
+		assert.Equal(t, start.Add(250*time.Second), samples[0].Timestamp)
+	})
+
+	t.Run("range crosses chunks", func(t *testing.T) {
+		samples := h.Range("temp", "gauge", start.Add(250*time.Second), start.Add(299*time.Second))
+		require.Len(t, samples, 50)
+		for i, s := range samples {
+			assert.Equal(t, float64(250+i), s.Value)
+		}
+	})
+
+	t.Run("empty ranges", func(t *testing.T) {
+		assert.Empty(t, h.Range("temp", "gauge", now.Add(time.Hour), now.Add(2*time.Hour)))
+		assert.Empty(t, h.Range("temp", "gauge", now, start))
+		assert.Empty(t, h.Range("temp", "counter", start, now))
+		assert.NotNil(t, h.Range("unknown", "gauge", start, now))
+	})
+
+	t.Run("unlimited history keeps all samples", func(t *testing.T) {
+		unlimited := NewHistory(HistoryUnlimited)
+		unlimited.now = h.now
+		for i := 0; i < 300; i++ {
+			unlimited.Append("temp", "gauge", float64(i))
+		}
+		assert.Len(t, unlimited.Range("temp", "gauge", start, now), 300)
+	})
+
+	t.Run("clock going backwards keeps order", func(t *testing.T) {
+		now = start
+		h.Append("temp", "gauge", 1000)
+		samples := h.Range("temp", "gauge", start, now.Add(time.Hour))
+		last := samples[len(samples)-1]
+		assert.Equal(t, float64(1000), last.Value)
+		assert.False(t, last.Timestamp.Before(samples[len(samples)-2].Timestamp))
+	})
+}
+
+func TestMetricsMapHistory(t *testing.T) {
+	h := NewHistory(0)
+	metricsMap := NewMetricsMap("", false, h)
+
+	_, err := metricsMap.SaveCounter("hits", 2)
+	require.NoError(t, err)
+	_, err = metricsMap.SaveCounter("hits", 3)
+	require.NoError(t, err)
+	_, err = metricsMap.SaveGauge("temp", 36.6)
+	require.NoError(t, err)
+
+	from, to := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+	counters := metricsMap.GetSamples("hits", "counter", from, to)
+	require.Len(t, counters, 2)
+	assert.Equal(t, float64(2), counters[0].Value)
+	assert.Equal(t, float64(5), counters[1].Value, "counter samples keep totals")
+
+	gauges := metricsMap.GetSamples("temp", "gauge", from, to)
+	require.Len(t, gauges, 1)
+	assert.Equal(t, 36.6, gauges[0].Value)
+
+	assert.Empty(t, NewMetricsMap("", false, nil).GetSamples("hits", "counter", from, to))
+}
+
+func TestHistoryCompact(t *testing.T) {
+	policy, err := retention.ParsePolicy("raw:1h,1m:24h,1h:72h")
+	require.NoError(t, err)
+
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	var clock time.Time
+	h := NewHistory(0)
+	h.now = func() time.Time { return clock }
+	record := func(age time.Duration, gauge, counter float64) {
+		clock = now.Add(-age)
+		h.Append("temp", "gauge", gauge)
+		h.Append("hits", "counter", counter)
+	}
+
+	record(100*time.Hour, 1, 1)               // older than retention
+	record(30*time.Hour+10*time.Minute, 2, 5) // 1h rollup
+	record(30*time.Hour+40*time.Minute, 4, 7)
+	record(2*time.Hour+50*time.Second, 10, 10) // 1m rollup
+	record(2*time.Hour+20*time.Second, 20, 12)
+	record(2*time.Hour+10*time.Second, 30, 15)
+	record(10*time.Minute, 5, 20) // raw
+	record(5*time.Minute, 6, 21)
+	clock = now.Add(-100 * time.Hour)
+	h.Append("old", "gauge", 1)
+
+	h.Compact(policy, now)
+
+	all := func(name, mType string) []models.Sample {
+		return h.Range(name, mType, now.Add(-200*time.Hour), now)
+	}
+	at := func(age time.Duration, value float64) models.Sample {
+		return models.Sample{Timestamp: now.Add(-age), Value: value}
+	}
+
+	assert.Equal(t, []models.Sample{
+		at(31*time.Hour, 3),
+		at(2*time.Hour+time.Minute, 20),
+		at(10*time.Minute, 5),
+		at(5*time.Minute, 6),
+	}, all("temp", "gauge"), "gauge rollups keep average")
+	assert.Equal(t, []models.Sample{
+		at(31*time.Hour, 7),
+		at(2*time.Hour+time.Minute, 15),
+		at(10*time.Minute, 20),
+		at(5*time.Minute, 21),
+	}, all("hits", "counter"), "counter rollups keep the last total")
+
+	h.Compact(policy, now)
+	assert.Len(t, all("temp", "gauge"), 4, "compaction is idempotent")
+
+	h.Compact(policy, now.Add(48*time.Hour))
+	assert.Equal(t, []models.Sample{at(3*time.Hour, 20)}, h.Range("temp", "gauge", now.Add(-200*time.Hour), now.Add(-2*time.Hour)),
+		"1m rollup is rolled up to hour bucket later")
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	assert.NotContains(t, h.series, seriesKey("old", "gauge"), "metric without samples is forgotten")
+}
@@ -8,25 +8,33 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/aykuli/observer/internal/models"
 	"github.com/aykuli/observer/internal/server/config"
+	"github.com/aykuli/observer/internal/server/retention"
 	"github.com/aykuli/observer/internal/server/storage"
 )
 
 type Storage struct {
 	memStorage storage.MetricsMap
 	logger     zap.SugaredLogger
+	filePath   string
+	stop       chan struct{}
+	saving     sync.WaitGroup
+	closeOnce  sync.Once
 }
 
 func NewStorage(options config.Config, logger zap.SugaredLogger) (*Storage, error) {
 	flushOnSave := options.FileStoragePath != "" && options.StoreInterval == 0
 	s := Storage{
-		memStorage: *storage.NewMetricsMap(options.FileStoragePath, flushOnSave),
+		memStorage: *storage.NewMetricsMap(options.FileStoragePath, flushOnSave, storage.NewHistory(historySize(options, logger))),
 		logger:     logger,
+		filePath:   options.FileStoragePath,
+		stop:       make(chan struct{}),
 	}
 
 	if options.FileStoragePath != "" {
@@ -42,6 +50,7 @@ func NewStorage(options config.Config, logger zap.SugaredLogger) (*Storage, erro
 		}
 
 		if options.StoreInterval > 0 {
+			s.saving.Add(1)
 			go s.startSaveMetricsTicker(options.StoreInterval)
 		}
 	}
@@ -49,6 +58,22 @@ func NewStorage(options config.Config, logger zap.SugaredLogger) (*Storage, erro
 	return &s, nil
 }
 
+// historySize returns per metric samples limit. Without explicit limit samples are kept till retention policy
+// removes them, because the limit would drop them before they are rolled up to coarser tiers.
+func historySize(options config.Config, logger zap.SugaredLogger) int {
+	policy, err := retention.ParsePolicy(options.Retention)
+	if err != nil || len(policy) == 0 {
+		return options.HistorySize
+	}
+	if options.HistorySize <= 0 {
+		return storage.HistoryUnlimited
+	}
+
+	logger.Warnw("history size drops samples before retention policy rolls them up, coarser tiers may get no data",
+		"event", "init history", "history_size", options.HistorySize, "retention", options.Retention)
+	return options.HistorySize
+}
+
 func (s *Storage) checkFile(filePath string) error {
 	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
 		if _, err = os.Create(filePath); err != nil {
@@ -60,16 +85,38 @@ func (s *Storage) checkFile(filePath string) error {
 }
 
 func (s *Storage) startSaveMetricsTicker(storeInterval int) {
+	defer s.saving.Done()
 	collectTicker := time.NewTicker(time.Duration(storeInterval) * time.Second)
 	defer collectTicker.Stop()
-	for range collectTicker.C {
-		err := s.memStorage.SaveToFile()
-		if err != nil {
-			s.logger.Errorln("failed metrics saving to local.", zap.Error(err))
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-collectTicker.C:
+			err := s.memStorage.SaveToFile()
+			if err != nil {
+				s.logger.Errorln("failed metrics saving to local.", zap.Error(err))
+			}
 		}
 	}
 }
 
+// Close stops periodic saving, waits for the running save to finish and writes the final metrics snapshot to the file.
+func (s *Storage) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.saving.Wait()
+		if s.filePath != "" {
+			if err = s.memStorage.SaveToFile(); err != nil {
+				err = newFSError("Close", err)
+			}
+		}
+	})
+
+	return err
+}
+
 func (s *Storage) Ping(ctx context.Context) error {
 	return nil
 }
@@ -93,21 +140,23 @@ func (s *Storage) GetMetrics(ctx context.Context) (string, error) {
 	return strings.Join(metrics, ",\n"), nil
 }
 
-func (s *Storage) ReadMetric(ctx context.Context, mName, mType string) (*models.Metric, error) {
-	outMt := models.Metric{ID: mName, MType: mType}
+// ReadMetric returns metric value. Metrics with labels are kept by names like "HeapAlloc{host=a}".
+func (s *Storage) ReadMetric(ctx context.Context, mName, mType string, labels map[string]string) (*models.Metric, error) {
+	outMt := models.Metric{ID: mName, MType: mType, Labels: labels}
+	key := models.SeriesName(mName, labels)
 	var value float64
 	var delta int64
 
 	switch mType {
 	case "gauge":
-		v, ok := s.memStorage.GetGauge(mName)
+		v, ok := s.memStorage.GetGauge(key)
 		if !ok {
 			return nil, errors.New("no such metric")
 		}
 		value = v
 		outMt.Value = &value
 	case "counter":
-		d, ok := s.memStorage.GetCounter(mName)
+		d, ok := s.memStorage.GetCounter(key)
 		if !ok {
 			return nil, errors.New("no such metric")
 		}
@@ -120,22 +169,39 @@ func (s *Storage) ReadMetric(ctx context.Context, mName, mType string) (*models.
 	return &outMt, nil
 }
 
+// ReadSamples returns metric samples recorded from `from` till `to` inclusively. History is kept in memory only
+// and starts anew on every application start.
+func (s *Storage) ReadSamples(ctx context.Context, mName, mType string, labels map[string]string, from, to time.Time) ([]models.Sample, error) {
+	if mType != "gauge" && mType != "counter" {
+		return nil, newFSError("ReadSamples", errors.New("no such metric type"))
+	}
+
+	return s.memStorage.GetSamples(models.SeriesName(mName, labels), mType, from, to), nil
+}
+
+// Compact downsamples and deletes old samples of in-memory history according to retention policy.
+func (s *Storage) Compact(ctx context.Context, policy retention.Policy, now time.Time) error {
+	s.memStorage.CompactHistory(policy, now)
+	return nil
+}
+
 func (s *Storage) SaveMetric(ctx context.Context, metric models.Metric) (*models.Metric, error) {
-	outMt := models.Metric{ID: metric.ID, MType: metric.MType}
+	outMt := models.Metric{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
+	key := models.SeriesName(metric.ID, metric.Labels)
 	var value float64
 	var delta int64
 
 	switch metric.MType {
 	case "gauge":
 		value = *metric.Value
-		newValue, err := s.memStorage.SaveGauge(metric.ID, value)
+		newValue, err := s.memStorage.SaveGauge(key, value)
 		if err != nil {
 			return nil, newFSError("SaveMetric", err)
 		}
 		outMt.Value = &newValue
 	case "counter":
 		delta = *metric.Delta
-		newDelta, err := s.memStorage.SaveCounter(metric.ID, delta)
+		newDelta, err := s.memStorage.SaveCounter(key, delta)
 		if err != nil {
 			return nil, newFSError("SaveMetric", err)
 		}
@@ -152,17 +218,18 @@ func (s *Storage) SaveBatch(ctx context.Context, metrics []models.Metric) ([]mod
 	var delta int64
 
 	for i, mt := range metrics {
-		outMt := models.Metric{ID: mt.ID, MType: mt.MType}
+		outMt := models.Metric{ID: mt.ID, MType: mt.MType, Labels: mt.Labels}
+		key := models.SeriesName(mt.ID, mt.Labels)
 		switch mt.MType {
 		case "gauge":
-			newValue, err := s.memStorage.SaveGauge(mt.ID, *mt.Value)
+			newValue, err := s.memStorage.SaveGauge(key, *mt.Value)
 			if err != nil {
 				return nil, newFSError("SaveBatch", err)
 			}
 			outMt.Value = &newValue
 		case "counter":
 			delta = *mt.Delta
-			newDelta, err := s.memStorage.SaveCounter(mt.ID, delta)
+			newDelta, err := s.memStorage.SaveCounter(key, delta)
 			if err != nil {
 				return nil, newFSError("SaveBatch", err)
 			}
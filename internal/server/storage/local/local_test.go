@@ -3,13 +3,17 @@ package local
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/aykuli/observer/internal/models"
 	"github.com/aykuli/observer/internal/server/config"
+	"github.com/aykuli/observer/internal/server/storage"
+	"github.com/aykuli/observer/internal/server/storage/storagetest"
 )
 
 func TestFileStorage(t *testing.T) {
@@ -94,4 +98,74 @@ func TestFileStorage(t *testing.T) {
 		require.Contains(t, metrics, "rand: 78")
 		require.Contains(t, metrics, "c2: 256")
 	})
+
+	t.Run("ReadSamples", func(t *testing.T) {
+		delta := int64(2)
+		_, err := store.SaveMetric(ctx, models.Metric{ID: "rand", MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+
+		samples, err := store.ReadSamples(ctx, "rand", "counter", nil, time.Now().Add(-time.Minute), time.Now())
+		require.NoError(t, err)
+		require.Len(t, samples, 2)
+		require.Equal(t, float64(78), samples[0].Value)
+		require.Equal(t, float64(80), samples[1].Value)
+
+		samples, err = store.ReadSamples(ctx, "rand", "counter", nil, time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, samples)
+
+		_, err = store.ReadSamples(ctx, "rand", "histogram", nil, time.Now().Add(-time.Minute), time.Now())
+		require.Error(t, err)
+	})
+}
+
+func TestFileStorageClose(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := *logger.Sugar()
+
+	options := config.Config{
+		StoreInterval:   300,
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+		Restore:         true,
+	}
+	store, err := NewStorage(options, sugar)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	delta := int64(42)
+	_, err = store.SaveMetric(ctx, models.Metric{ID: "requests", MType: "counter", Delta: &delta})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Close())
+	require.NoError(t, store.Close(), "second close is no-op")
+
+	restored, err := NewStorage(options, sugar)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	metric, err := restored.ReadMetric(ctx, "requests", "counter", nil)
+	require.NoError(t, err)
+	require.Equal(t, delta, *metric.Delta)
+}
+
+func TestQueryConformance(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+
+	store, err := NewStorage(config.Config{}, *logger.Sugar())
+	require.NoError(t, err)
+
+	storagetest.RunQueryConformance(t, store)
+}
+
+func TestHistorySize(t *testing.T) {
+	logger := zap.NewExample()
+	defer logger.Sync()
+	sugar := *logger.Sugar()
+
+	require.Equal(t, storage.HistoryUnlimited, historySize(config.Config{Retention: "raw:48h,1m:30d"}, sugar),
+		"retention policy bounds history without explicit size")
+	require.Equal(t, 500, historySize(config.Config{Retention: "raw:48h,1m:30d", HistorySize: 500}, sugar))
+	require.Equal(t, 0, historySize(config.Config{Retention: "none"}, sugar), "default size without policy")
 }
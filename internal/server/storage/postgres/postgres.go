@@ -12,8 +12,12 @@ import (
 
 	"github.com/aykuli/observer/internal/models"
 	"github.com/aykuli/observer/internal/server/repository"
+	"github.com/aykuli/observer/internal/server/retention"
 )
 
+// deleteBatchSize limits rows deleted by one statement during compaction.
+const deleteBatchSize = 10000
+
 type DBStorage struct {
 	instance *pgxpool.Pool
 }
@@ -107,13 +111,13 @@ func (s *DBStorage) parseMetrics(metrics []models.Metric) string {
 		case "counter":
 			valueStr = fmt.Sprintf("%d", *m.Delta)
 		}
-		pair[i] = "   " + m.ID + valueStr
+		pair[i] = "   " + models.SeriesName(m.ID, m.Labels) + valueStr
 	}
 
 	return strings.Join(pair, ",\n")
 }
 
-func (s *DBStorage) ReadMetric(ctx context.Context, mName, mType string) (*models.Metric, error) {
+func (s *DBStorage) ReadMetric(ctx context.Context, mName, mType string, labels map[string]string) (*models.Metric, error) {
 	conn, err := s.instance.Acquire(ctx)
 	if err != nil {
 		return nil, newDBError(err)
@@ -121,13 +125,77 @@ func (s *DBStorage) ReadMetric(ctx context.Context, mName, mType string) (*model
 	defer conn.Release()
 
 	metricsRepo := repository.NewMetricsRepository(conn)
-	metric, err := metricsRepo.FindByNameAndType(ctx, mName, mType)
+	metric, err := metricsRepo.FindByNameAndType(ctx, mName, mType, labels)
 	if err != nil {
 		return nil, newDBError(err)
 	}
 	return metric, nil
 }
 
+// ReadSamples returns metric samples saved from `from` till `to` inclusively.
+func (s *DBStorage) ReadSamples(ctx context.Context, mName, mType string, labels map[string]string, from, to time.Time) ([]models.Sample, error) {
+	conn, err := s.instance.Acquire(ctx)
+	if err != nil {
+		return nil, newDBError(err)
+	}
+	defer conn.Release()
+
+	metricsRepo := repository.NewMetricsRepository(conn)
+	samples, err := metricsRepo.SelectSamples(ctx, mName, mType, labels, from, to)
+	if err != nil {
+		return nil, newDBError(err)
+	}
+	return samples, nil
+}
+
+// Compact deletes samples older than policy retention and replaces older samples with rollups of policy tiers.
+// Every metric is rolled up in its own transaction.
+func (s *DBStorage) Compact(ctx context.Context, policy retention.Policy, now time.Time) error {
+	if len(policy) == 0 {
+		return nil
+	}
+
+	conn, err := s.instance.Acquire(ctx)
+	if err != nil {
+		return newDBError(err)
+	}
+	defer conn.Release()
+
+	metricsRepo := repository.NewMetricsRepository(conn)
+	if _, err = metricsRepo.DeleteSamplesBefore(ctx, policy.DeleteBefore(now), deleteBatchSize); err != nil {
+		return newDBError(err)
+	}
+
+	for i, tier := range policy {
+		if tier.Resolution == 0 {
+			continue
+		}
+		before := policy.RollupBefore(i, now)
+
+		series, err := metricsRepo.SelectRollupSeries(ctx, tier.Resolution, before)
+		if err != nil {
+			return newDBError(err)
+		}
+		for _, m := range series {
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return newDBError(err)
+			}
+			if err = metricsRepo.RollupSeries(ctx, tx, m, tier.Resolution, before); err != nil {
+				if rbErr := tx.Rollback(ctx); rbErr != nil {
+					return newDBError(rbErr)
+				}
+				return newDBError(err)
+			}
+			if err = tx.Commit(ctx); err != nil {
+				return newDBError(err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func (s *DBStorage) SaveMetric(ctx context.Context, metric models.Metric) (*models.Metric, error) {
 	conn, err := s.instance.Acquire(ctx)
 	if err != nil {
@@ -3,27 +3,26 @@ package postgres
 import (
 	"context"
 	"math/rand"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/require"
 
 	"github.com/aykuli/observer/internal/models"
 	"github.com/aykuli/observer/internal/server/config"
+	"github.com/aykuli/observer/internal/server/retention"
+	"github.com/aykuli/observer/internal/server/storage/storagetest"
 )
 
 var envFolder = "../../../../.env"
 
 func TestPostgresStorage(t *testing.T) {
 	ctx := context.Background()
-	dsn := config.Options.DatabaseDsn
+	dsn := testDSN()
 	if dsn == "" {
-		envFile, err := godotenv.Read(envFolder)
-		require.NoError(t, err)
-		dsn = envFile["POSTGRES_TEST_DSN"]
-	}
-	if dsn == "" {
-		return
+		t.Skip("no test database: set DATABASE_DSN or POSTGRES_TEST_DSN in environment or .env file")
 	}
 
 	// test NewStorage
@@ -47,7 +46,7 @@ func TestPostgresStorage(t *testing.T) {
 	require.Equal(t, *outMetric, metric)
 
 	// test ReadMetric
-	readMetric, err := dbStorage.ReadMetric(ctx, metric.ID, "gauge")
+	readMetric, err := dbStorage.ReadMetric(ctx, metric.ID, "gauge", nil)
 	require.NoError(t, err)
 	require.Equal(t, *readMetric, metric)
 
@@ -61,4 +60,43 @@ func TestPostgresStorage(t *testing.T) {
 	require.NoError(t, err)
 	require.Contains(t, outMetrics, metricsBatch[0])
 	require.Contains(t, outMetrics, metricsBatch[1])
+
+	// test ReadSamples
+	samples, err := dbStorage.ReadSamples(ctx, metric.ID, "gauge", nil, time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.NotEmpty(t, samples)
+	require.Equal(t, value, samples[len(samples)-1].Value)
+
+	storagetest.RunQueryConformance(t, dbStorage)
+
+	// test Compact
+	policy, err := retention.ParsePolicy("raw:1h,1m:24h")
+	require.NoError(t, err)
+	require.NoError(t, dbStorage.Compact(ctx, policy, time.Now().Add(2*time.Hour)))
+	samples, err = dbStorage.ReadSamples(ctx, metric.ID, "gauge", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.NotEmpty(t, samples)
+	for _, sample := range samples {
+		require.True(t, sample.Timestamp.Equal(retention.Align(sample.Timestamp, time.Minute)), "samples are rolled up to minutes")
+	}
+
+	require.NoError(t, dbStorage.Compact(ctx, policy, time.Now().Add(48*time.Hour)))
+	samples, err = dbStorage.ReadSamples(ctx, metric.ID, "gauge", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, samples)
+}
+
+// testDSN returns test database source name from flags, environment or .env file.
+func testDSN() string {
+	if config.Options.DatabaseDsn != "" {
+		return config.Options.DatabaseDsn
+	}
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		return dsn
+	}
+	envFile, err := godotenv.Read(envFolder)
+	if err != nil {
+		return ""
+	}
+	return envFile["POSTGRES_TEST_DSN"]
 }
@@ -5,17 +5,21 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/retention"
 )
 
 // Storage interface provides methods need to be provided by the Storage object.
+// Metrics are identified by name, type and labels.
 type Storage interface {
 	Ping(ctx context.Context) error
 	GetMetrics(ctx context.Context) (string, error)
-	ReadMetric(ctx context.Context, metricName, metricType string) (*models.Metric, error)
+	ReadMetric(ctx context.Context, metricName, metricType string, labels map[string]string) (*models.Metric, error)
 	SaveMetric(ctx context.Context, metric models.Metric) (*models.Metric, error)
 	SaveBatch(ctx context.Context, metrics []models.Metric) ([]models.Metric, error)
+	ReadSamples(ctx context.Context, metricName, metricType string, labels map[string]string, from, to time.Time) ([]models.Sample, error)
 }
 
 type GaugeMetrics map[string]float64
@@ -33,10 +37,12 @@ type MetricsMap struct {
 	mutex       sync.RWMutex
 	filepath    string
 	flushOnSave bool
+	history     *History
 }
 
 // NewMetricsMap creates MetricsMap object based on configuration provided on application start.
-func NewMetricsMap(filepath string, flushOnSave bool) *MetricsMap {
+// If history is provided, every saved value is recorded there as well.
+func NewMetricsMap(filepath string, flushOnSave bool, history *History) *MetricsMap {
 	return &MetricsMap{
 		metrics: Metrics{
 			Gauge:   make(map[string]float64),
@@ -45,6 +51,7 @@ func NewMetricsMap(filepath string, flushOnSave bool) *MetricsMap {
 		mutex:       sync.RWMutex{},
 		filepath:    filepath,
 		flushOnSave: flushOnSave,
+		history:     history,
 	}
 }
 
@@ -70,6 +77,9 @@ func (ms *MetricsMap) SaveGauge(mName string, value float64) (float64, error) {
 	defer ms.mutex.Unlock()
 
 	ms.metrics.Gauge[mName] = value
+	if ms.history != nil {
+		ms.history.Append(mName, "gauge", value)
+	}
 
 	if ms.flushOnSave {
 		if err := ms.flushToDisk(); err != nil {
@@ -85,6 +95,9 @@ func (ms *MetricsMap) SaveCounter(mName string, delta int64) (int64, error) {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 	ms.metrics.Counter[mName] += delta
+	if ms.history != nil {
+		ms.history.Append(mName, "counter", float64(ms.metrics.Counter[mName]))
+	}
 
 	if ms.flushOnSave {
 		if err := ms.flushToDisk(); err != nil {
@@ -95,6 +108,21 @@ func (ms *MetricsMap) SaveCounter(mName string, delta int64) (int64, error) {
 	return ms.metrics.Counter[mName], nil
 }
 
+// GetSamples returns metric samples recorded from `from` till `to` inclusively.
+func (ms *MetricsMap) GetSamples(mName, mType string, from, to time.Time) []models.Sample {
+	if ms.history == nil {
+		return []models.Sample{}
+	}
+	return ms.history.Range(mName, mType, from, to)
+}
+
+// CompactHistory downsamples and deletes old samples according to retention policy.
+func (ms *MetricsMap) CompactHistory(policy retention.Policy, now time.Time) {
+	if ms.history != nil {
+		ms.history.Compact(policy, now)
+	}
+}
+
 // LoadFromFile reads metrics from file and saves it to the object.
 func (ms *MetricsMap) LoadFromFile() error {
 	ms.mutex.RLock()
@@ -138,10 +166,10 @@ func (ms *MetricsMap) flushToDisk() error {
 	return nil
 }
 
-// SaveToFile saves metrics from the memory to file.
+// SaveToFile saves metrics from the memory to file. Write lock keeps concurrent saves from mixing file content.
 func (ms *MetricsMap) SaveToFile() error {
-	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
 	return ms.flushToDisk()
 }
 
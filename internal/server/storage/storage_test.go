@@ -10,7 +10,7 @@ import (
 )
 
 func TestMemStorage(t *testing.T) {
-	metricsMap := NewMetricsMap(config.Options.FileStoragePath, true)
+	metricsMap := NewMetricsMap(config.Options.FileStoragePath, true, nil)
 	require.Empty(t, metricsMap.metrics.Counter)
 	require.Empty(t, metricsMap.metrics.Gauge)
 
@@ -0,0 +1,125 @@
+// Package storagetest provides conformance tests every storage.Storage implementation should pass.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aykuli/observer/internal/models"
+	"github.com/aykuli/observer/internal/server/query"
+	"github.com/aykuli/observer/internal/server/storage"
+)
+
+// queryStep is so long that all samples written during the test fall into one bucket.
+const queryStep = 1000 * time.Hour
+
+// RunQueryConformance saves metrics history to storage and checks range queries give the same results on any backend.
+// Metric names are unique for every run, so storage may keep data of previous runs.
+func RunQueryConformance(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	suffix := fmt.Sprintf("_%d", time.Now().UnixNano())
+	gaugeID, counterID := "conformance_gauge"+suffix, "conformance_counter"+suffix
+	hostLabels := map[string]string{"host": "a", "service": "conformance"}
+
+	from := time.Now().Add(-time.Second)
+	for _, v := range []float64{4, 1, 3, 2} {
+		value := v
+		_, err := s.SaveMetric(ctx, models.Metric{ID: gaugeID, MType: "gauge", Value: &value})
+		require.NoError(t, err)
+	}
+	for _, d := range []int64{5, 10} {
+		delta := d
+		_, err := s.SaveMetric(ctx, models.Metric{ID: counterID, MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+	}
+	value, delta, labeled := 10.0, int64(20), 100.0
+	_, err := s.SaveBatch(ctx, []models.Metric{
+		{ID: gaugeID, MType: "gauge", Value: &value},
+		{ID: counterID, MType: "counter", Delta: &delta},
+		{ID: gaugeID, MType: "gauge", Value: &labeled, Labels: hostLabels},
+	})
+	require.NoError(t, err)
+	to := time.Now().Add(time.Second)
+
+	t.Run("ReadSamples", func(t *testing.T) {
+		samples, err := s.ReadSamples(ctx, gaugeID, "gauge", nil, from, to)
+		require.NoError(t, err)
+		require.Len(t, samples, 5)
+		for i, want := range []float64{4, 1, 3, 2, 10} {
+			assert.Equal(t, want, samples[i].Value)
+			if i > 0 {
+				assert.False(t, samples[i].Timestamp.Before(samples[i-1].Timestamp), "samples are in time order")
+			}
+			assert.Zero(t, samples[i].Timestamp.Nanosecond()%int(time.Microsecond), "timestamps keep microseconds")
+		}
+
+		samples, err = s.ReadSamples(ctx, counterID, "counter", nil, from, to)
+		require.NoError(t, err)
+		require.Len(t, samples, 3)
+		assert.Equal(t, []float64{5, 15, 35}, []float64{samples[0].Value, samples[1].Value, samples[2].Value})
+
+		last := samples[2].Timestamp
+		samples, err = s.ReadSamples(ctx, counterID, "counter", nil, last, last)
+		require.NoError(t, err)
+		require.Len(t, samples, 1, "range bounds are inclusive")
+
+		samples, err = s.ReadSamples(ctx, counterID, "counter", nil, to, to.Add(time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, samples)
+
+		samples, err = s.ReadSamples(ctx, "conformance_unknown"+suffix, "gauge", nil, from, to)
+		require.NoError(t, err)
+		assert.Empty(t, samples)
+
+		samples, err = s.ReadSamples(ctx, gaugeID, "gauge", map[string]string{"service": "conformance", "host": "a"}, from, to)
+		require.NoError(t, err)
+		require.Len(t, samples, 1, "metrics with labels are kept separately")
+		assert.Equal(t, labeled, samples[0].Value)
+
+		metric, err := s.ReadMetric(ctx, gaugeID, "gauge", hostLabels)
+		require.NoError(t, err)
+		assert.Equal(t, labeled, *metric.Value)
+		assert.Equal(t, hostLabels, metric.Labels)
+
+		metric, err = s.ReadMetric(ctx, gaugeID, "gauge", nil)
+		require.NoError(t, err)
+		assert.Equal(t, value, *metric.Value)
+	})
+
+	tests := []struct {
+		id          string
+		mType       string
+		labels      map[string]string
+		aggregation string
+		want        float64
+	}{
+		{id: gaugeID, mType: "gauge", aggregation: query.Avg, want: 4},
+		{id: gaugeID, mType: "gauge", aggregation: query.Min, want: 1},
+		{id: gaugeID, mType: "gauge", aggregation: query.Max, want: 10},
+		{id: gaugeID, mType: "gauge", aggregation: query.Sum, want: 20},
+		{id: gaugeID, mType: "gauge", aggregation: query.Count, want: 5},
+		{id: gaugeID, mType: "gauge", aggregation: query.Last, want: 10},
+		{id: gaugeID, mType: "gauge", aggregation: query.P50, want: 3},
+		{id: gaugeID, mType: "gauge", aggregation: query.P95, want: 10},
+		{id: gaugeID, mType: "gauge", aggregation: query.P99, want: 10},
+		{id: counterID, mType: "counter", aggregation: query.Last, want: 35},
+		{id: counterID, mType: "counter", aggregation: query.Increase, want: 30},
+		{id: counterID, mType: "counter", aggregation: query.Rate, want: 30 / queryStep.Seconds()},
+		{id: gaugeID, mType: "gauge", labels: hostLabels, aggregation: query.Count, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(models.SeriesName(tt.mType, tt.labels)+" "+tt.aggregation, func(t *testing.T) {
+			req := query.Request{ID: tt.id, MType: tt.mType, Labels: tt.labels, From: from, To: to, Step: queryStep, Aggregation: tt.aggregation}
+			result, err := query.Run(ctx, s, req)
+			require.NoError(t, err)
+			require.Len(t, result.Points, 1)
+			assert.InDelta(t, tt.want, result.Points[0].Value, 1e-12)
+			assert.False(t, result.Points[0].Timestamp.After(from))
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// Package subnet provides checking whether metrics are sent by agents from trusted subnet.
+package subnet
+
+import (
+	"net"
+	"strings"
+)
+
+// RealIPHeader is request header where agent puts its own IP address.
+const RealIPHeader = "X-Real-IP"
+
+// Checker struct keeps trusted subnet and the source of client address.
+type Checker struct {
+	network       *net.IPNet
+	useRemoteAddr bool
+}
+
+// New parses trusted subnet in CIDR notation. If cidr is empty, nil checker allowing everything is returned.
+// Client address is taken from X-Real-IP header, or from connection remote address if useRemoteAddr is set.
+func New(cidr string, useRemoteAddr bool) (*Checker, error) {
+	if cidr == "" {
+		return nil, nil
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Checker{network: network, useRemoteAddr: useRemoteAddr}, nil
+}
+
+// Allowed reports whether client belongs to trusted subnet. Remote address might be provided with port.
+func (c *Checker) Allowed(realIP, remoteAddr string) bool {
+	if c == nil {
+		return true
+	}
+
+	addr := strings.TrimSpace(realIP)
+	if c.useRemoteAddr {
+		addr = remoteAddr
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			addr = host
+		}
+	}
+
+	ip := net.ParseIP(addr)
+	return ip != nil && c.network.Contains(ip)
+}
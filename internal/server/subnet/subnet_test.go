@@ -0,0 +1,48 @@
+package subnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker(t *testing.T) {
+	t.Run("empty subnet allows everything", func(t *testing.T) {
+		c, err := New("", false)
+		require.NoError(t, err)
+		assert.True(t, c.Allowed("", ""))
+	})
+
+	t.Run("wrong subnet", func(t *testing.T) {
+		_, err := New("10.0.0.1", false)
+		assert.Error(t, err)
+	})
+
+	t.Run("X-Real-IP header", func(t *testing.T) {
+		c, err := New("192.168.1.0/24", false)
+		require.NoError(t, err)
+
+		assert.True(t, c.Allowed("192.168.1.15", "10.0.0.1:5000"))
+		assert.False(t, c.Allowed("192.168.2.15", "192.168.1.15:5000"))
+		assert.False(t, c.Allowed("", "192.168.1.15:5000"))
+		assert.False(t, c.Allowed("not ip", ""))
+	})
+
+	t.Run("remote address", func(t *testing.T) {
+		c, err := New("10.0.0.0/8", true)
+		require.NoError(t, err)
+
+		assert.True(t, c.Allowed("", "10.1.2.3:5000"))
+		assert.True(t, c.Allowed("192.168.1.1", "10.1.2.3"))
+		assert.False(t, c.Allowed("10.1.2.3", "192.168.1.1:5000"))
+	})
+
+	t.Run("IPv6", func(t *testing.T) {
+		c, err := New("fd00::/8", true)
+		require.NoError(t, err)
+
+		assert.True(t, c.Allowed("", "[fd00::1]:5000"))
+		assert.False(t, c.Allowed("", "[::1]:5000"))
+	})
+}
@@ -0,0 +1,227 @@
+package sign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request signature headers. Signature covers timestamp, nonce, method, path with query, agent identity headers
+// and raw request body.
+const (
+	HashHeader      = "HashSHA256"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+)
+
+// Agent identity headers server keeps agents registry by, they are signed with request so they can't be forged.
+// gRPC agents send them in metadata with lowercase keys.
+const (
+	AgentIDHeader        = "X-Agent-ID"
+	AgentVersionHeader   = "X-Agent-Version"
+	ReportIntervalHeader = "X-Agent-Report-Interval"
+)
+
+var identityHeaders = []string{AgentIDHeader, AgentVersionHeader, ReportIntervalHeader}
+
+// SkewDefault is default allowed difference between agent and server clocks.
+const SkewDefault = 5 * time.Minute
+
+// Request verification errors
+var (
+	ErrNoSignature = errors.New("request is not signed")
+	ErrNoKey       = errors.New("verifier has no key")
+	ErrSkew        = errors.New("request timestamp is out of allowed window")
+	ErrSignature   = errors.New("request signature is wrong")
+	ErrReplay      = errors.New("request nonce was already used")
+)
+
+// RequestHash returns signature of request parts. Header function provides timestamp in unix seconds, nonce
+// and agent identity headers, missing ones are empty.
+func RequestHash(key, method, path string, body []byte, header func(key string) string) string {
+	return GetHmacString(canonicalRequest(method, path, body, header), key)
+}
+
+// SetRequestHeaders signs request with fresh timestamp and nonce. Agent identity headers should be set before.
+// It should be called for every attempt to send the request, because server rejects reused nonces.
+func SetRequestHeaders(header http.Header, key, method, path string, body []byte) error {
+	timestamp, nonce, hash, err := SignRequest(key, method, path, body, header.Get)
+	if err != nil {
+		return err
+	}
+
+	header.Set(TimestampHeader, timestamp)
+	header.Set(NonceHeader, nonce)
+	header.Set(HashHeader, hash)
+
+	return nil
+}
+
+// SignRequest returns fresh timestamp, nonce and signature of request parts with agent identity headers
+// provided by header function. It is used by transports sending them not in HTTP headers, like gRPC metadata.
+func SignRequest(key, method, path string, body []byte, header func(key string) string) (timestamp, nonce, hash string, err error) {
+	if nonce, err = NewNonce(); err != nil {
+		return "", "", "", err
+	}
+	timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+
+	signed := func(key string) string {
+		switch key {
+		case TimestampHeader:
+			return timestamp
+		case NonceHeader:
+			return nonce
+		default:
+			return header(key)
+		}
+	}
+	return timestamp, nonce, RequestHash(key, method, path, body, signed), nil
+}
+
+// NewNonce returns random hex string.
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func canonicalRequest(method, path string, body []byte, header func(key string) string) []byte {
+	var b bytes.Buffer
+	b.WriteString(header(TimestampHeader))
+	b.WriteByte('\n')
+	b.WriteString(header(NonceHeader))
+	b.WriteByte('\n')
+	b.WriteString(method)
+	b.WriteByte('\n')
+	b.WriteString(path)
+	b.WriteByte('\n')
+	for _, name := range identityHeaders {
+		b.WriteString(header(name))
+		b.WriteByte('\n')
+	}
+	b.Write(body)
+	return b.Bytes()
+}
+
+// Verifier struct checks request signatures and remembers used nonces while their timestamps are acceptable.
+type Verifier struct {
+	// Legacy allows requests signed the old way, with HashSHA256 header over body only and without timestamp
+	// and nonce. Such requests can be replayed, it is meant for transition of agents to new signature only.
+	Legacy bool
+
+	key    string
+	skew   time.Duration
+	now    func() time.Time
+	mutex  sync.Mutex
+	nonces map[string]time.Time
+	prune  time.Time
+}
+
+// NewVerifier creates Verifier accepting requests signed not earlier or later than skew from now.
+// Key must not be empty, Verifier with empty key rejects all requests.
+func NewVerifier(key string, skew time.Duration) *Verifier {
+	if skew <= 0 {
+		skew = SkewDefault
+	}
+	return &Verifier{key: key, skew: skew, now: time.Now, nonces: make(map[string]time.Time)}
+}
+
+// Verify checks request parts signature, timestamp and nonce. Header function provides signature, timestamp,
+// nonce and agent identity headers. Nonce is remembered only if request is valid.
+// If Legacy is set, requests with body signature only are accepted too.
+func (v *Verifier) Verify(method, path string, body []byte, header func(key string) string) error {
+	if v.key == "" {
+		return ErrNoKey
+	}
+
+	timestamp, nonce, hashString := header(TimestampHeader), header(NonceHeader), header(HashHeader)
+	if v.Legacy && timestamp == "" && nonce == "" && hashString != "" {
+		if !VerifyBytes(body, v.key, hashString) {
+			return ErrSignature
+		}
+		return nil
+	}
+	if timestamp == "" || nonce == "" || hashString == "" {
+		return ErrNoSignature
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSkew
+	}
+	now := v.now()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-v.skew)) || signedAt.After(now.Add(v.skew)) {
+		return ErrSkew
+	}
+
+	if !VerifyBytes(canonicalRequest(method, path, body, header), v.key, hashString) {
+		return ErrSignature
+	}
+
+	if !v.remember(nonce, signedAt.Add(v.skew), now) {
+		return ErrReplay
+	}
+
+	return nil
+}
+
+// remember keeps nonce till expiration, when its timestamp is out of window anyway. False is returned for known nonce.
+func (v *Verifier) remember(nonce string, expiration, now time.Time) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if now.After(v.prune) {
+		for n, exp := range v.nonces {
+			if now.After(exp) {
+				delete(v.nonces, n)
+			}
+		}
+		v.prune = now.Add(v.skew)
+	}
+
+	if _, ok := v.nonces[nonce]; ok {
+		return false
+	}
+	v.nonces[nonce] = expiration
+
+	return true
+}
+
+// Middleware verifies signature over raw request body, so it should be placed after body decompression.
+// Requests failed verification are rejected with 401 Unauthorized, so agents can tell them from invalid bodies.
+// Signed path is request URI with query string as it was sent, so query parameters like labels can't be changed.
+// If verifier is nil, requests are passed as they are.
+func (v *Verifier) Middleware(h http.Handler) http.Handler {
+	if v == nil {
+		return h
+	}
+
+	verifyFn := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "cannot read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+
+		err = v.Verify(r.Method, r.URL.RequestURI(), body, r.Header.Get)
+		if err != nil {
+			http.Error(w, "cannot serve this agent: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(verifyFn)
+}
@@ -0,0 +1,180 @@
+package sign
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	key := "secret"
+	now := time.Unix(1700000000, 0)
+	v := NewVerifier(key, time.Minute)
+	v.now = func() time.Time { return now }
+
+	body := []byte(`[{"id":"hits","type":"counter","delta":1}]`)
+	signed := func(ts time.Time, nonce string) http.Header {
+		header := http.Header{}
+		header.Set(TimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+		header.Set(NonceHeader, nonce)
+		header.Set(AgentIDHeader, "web-1")
+		header.Set(HashHeader, RequestHash(key, http.MethodPost, "/updates/", body, header.Get))
+		return header
+	}
+	with := func(header http.Header, key, value string) http.Header {
+		changed := header.Clone()
+		changed.Set(key, value)
+		return changed
+	}
+
+	t.Run("valid request", func(t *testing.T) {
+		assert.NoError(t, v.Verify(http.MethodPost, "/updates/", body, signed(now, "n1").Get))
+	})
+
+	t.Run("replayed nonce", func(t *testing.T) {
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, signed(now, "n1").Get), ErrReplay)
+	})
+
+	t.Run("changed request parts", func(t *testing.T) {
+		header := signed(now, "n2")
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", []byte(`[]`), header.Get), ErrSignature)
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/update/counter/hits/100", body, header.Get), ErrSignature)
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, with(header, NonceHeader, "n3").Get), ErrSignature)
+		assert.NoError(t, v.Verify(http.MethodPost, "/updates/", body, header.Get), "nonce of rejected requests isn't remembered")
+	})
+
+	t.Run("changed identity headers", func(t *testing.T) {
+		header := signed(now, "n8")
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, with(header, AgentIDHeader, "web-2").Get), ErrSignature)
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, with(header, AgentVersionHeader, "v9").Get), ErrSignature)
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, with(header, ReportIntervalHeader, "1").Get), ErrSignature)
+		assert.NoError(t, v.Verify(http.MethodPost, "/updates/", body, header.Get))
+	})
+
+	t.Run("timestamp out of window", func(t *testing.T) {
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, signed(now.Add(-2*time.Minute), "n4").Get), ErrSkew)
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, signed(now.Add(2*time.Minute), "n5").Get), ErrSkew)
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, with(signed(now, "n6"), TimestampHeader, "yesterday").Get), ErrSkew)
+	})
+
+	t.Run("not signed", func(t *testing.T) {
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, http.Header{}.Get), ErrNoSignature)
+	})
+
+	t.Run("legacy body signature", func(t *testing.T) {
+		legacy := http.Header{}
+		legacy.Set(HashHeader, GetHmacString(body, key))
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, legacy.Get), ErrNoSignature)
+
+		v.Legacy = true
+		defer func() { v.Legacy = false }()
+		assert.NoError(t, v.Verify(http.MethodPost, "/updates/", body, legacy.Get))
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", []byte(`[]`), legacy.Get), ErrSignature)
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, http.Header{}.Get), ErrNoSignature)
+		assert.ErrorIs(t, v.Verify(http.MethodPost, "/updates/", body, with(legacy, NonceHeader, "n8").Get), ErrNoSignature,
+			"partly new signatures are checked the new way")
+	})
+
+	t.Run("empty key", func(t *testing.T) {
+		header := signed(now, "n9")
+		assert.ErrorIs(t, NewVerifier("", time.Minute).Verify(http.MethodPost, "/updates/", body, header.Get), ErrNoKey)
+	})
+
+	t.Run("expired nonces are forgotten", func(t *testing.T) {
+		now = now.Add(3 * time.Minute)
+		require.NoError(t, v.Verify(http.MethodPost, "/updates/", body, signed(now, "n7").Get))
+
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+		assert.NotContains(t, v.nonces, "n1")
+		assert.Contains(t, v.nonces, "n7")
+	})
+}
+
+func TestVerifierConcurrentReplay(t *testing.T) {
+	key := "secret"
+	v := NewVerifier(key, time.Minute)
+	header := http.Header{}
+	require.NoError(t, SetRequestHeaders(header, key, http.MethodPost, "/update/", nil))
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := v.Verify(http.MethodPost, "/update/", nil, header.Get)
+			if err == nil {
+				mutex.Lock()
+				accepted++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, accepted)
+}
+
+func TestVerifierMiddleware(t *testing.T) {
+	key := "secret"
+	var got []byte
+	handler := NewVerifier(key, 0).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+	}))
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	post := func(path string, body []byte, sign bool) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		if sign {
+			require.NoError(t, SetRequestHeaders(req.Header, key, http.MethodPost, path, body))
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Raw body is signed as it is, whitespace and field order don't matter.
+	body := []byte(`{ "type": "gauge", "id": "temp", "value": 1.50 }`)
+	assert.Equal(t, http.StatusOK, post("/update/", body, true))
+	assert.Equal(t, body, got)
+
+	assert.Equal(t, http.StatusOK, post("/update/counter/hits/1", nil, true))
+	assert.Equal(t, http.StatusUnauthorized, post("/update/counter/hits/1", nil, false))
+
+	// Query string is signed with path.
+	assert.Equal(t, http.StatusOK, post("/update/counter/hits/1?labels=host=a", nil, true))
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/counter/hits/1?labels=host=b", nil)
+	require.NoError(t, err)
+	require.NoError(t, SetRequestHeaders(req.Header, key, http.MethodPost, "/update/counter/hits/1?labels=host=a", nil))
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "tampered query string")
+
+	// Agent identity headers are signed too.
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/update/counter/hits/1", nil)
+	require.NoError(t, err)
+	req.Header.Set(AgentIDHeader, "web-1")
+	require.NoError(t, SetRequestHeaders(req.Header, key, http.MethodPost, "/update/counter/hits/1", nil))
+	req.Header.Set(AgentIDHeader, "web-2")
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "forged agent ID")
+
+	var nilVerifier *Verifier
+	assert.NotNil(t, nilVerifier.Middleware(handler))
+}
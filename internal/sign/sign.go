@@ -22,7 +22,7 @@ func GetHmacString(body []byte, key string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// Verify returns true/false value based on provided key
+// Verify returns true/false value based on provided key. Messages are not checked if key or hash is empty.
 func Verify(msg interface{}, key, hashString string) bool {
 	if key == "" || hashString == "" {
 		return true
@@ -33,13 +33,23 @@ func Verify(msg interface{}, key, hashString string) bool {
 		return false
 	}
 
+	return VerifyBytes(byteData, key, hashString)
+}
+
+// VerifyBytes returns true/false value based on provided key for already encoded message.
+// Empty key or hash never verifies, callers decide themselves whether unsigned messages are accepted.
+func VerifyBytes(body []byte, key, hashString string) bool {
+	if key == "" || hashString == "" {
+		return false
+	}
+
 	sig, err := hex.DecodeString(hashString)
 	if err != nil {
 		return false
 	}
 
 	mac := hmac.New(sha256.New, []byte(key))
-	if _, err = mac.Write(byteData); err != nil {
+	if _, err = mac.Write(body); err != nil {
 		return false
 	}
 
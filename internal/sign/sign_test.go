@@ -36,4 +36,15 @@ func TestSign(t *testing.T) {
 		equal := Verify(metric, "another key", hashString)
 		assert.False(t, equal)
 	})
+
+	t.Run("verify encoded bytes", func(t *testing.T) {
+		body := []byte("encoded message")
+		hashString := GetHmacString(body, key)
+
+		assert.True(t, VerifyBytes(body, key, hashString))
+		assert.False(t, VerifyBytes([]byte("changed message"), key, hashString))
+		assert.False(t, VerifyBytes(body, key, "not hex"))
+		assert.False(t, VerifyBytes(body, "", hashString), "empty key never verifies")
+		assert.False(t, VerifyBytes(body, key, ""), "empty hash never verifies")
+	})
 }